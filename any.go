@@ -0,0 +1,106 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AnyWord is the name of the binding reported by a pattern constructed by Any
+// to identify which of its alternatives matched. Its value is the index of the
+// matching alternative among the arguments to Any.
+const AnyWord = "#"
+
+// Any constructs a pattern that matches any of the given patterns. The Match
+// and Search methods of the result behave as if each of ps were tried at each
+// position, preferring earlier patterns over later ones. The bindings for a
+// match begin with an AnyWord binding giving the index in ps of the pattern
+// that matched, followed by the bindings for that pattern alone. The same word
+// name may be used in more than one of ps.
+//
+// The result has no template, so its String is empty, and the Apply methods
+// and MatchApprox report errors for it. It is an error if ps is empty, or if
+// any of ps is nil or was itself constructed by Any.
+//
+// Options that change how Match treats the needle as a whole, namely
+// AnchorStart, AnchorEnd, NoEmpty, SameValue, UnicodeFold, and TrimSpace, are
+// not supported on the alternatives, and Any reports an error if any of ps
+// uses them. Other options apply to each alternative separately.
+func Any(ps ...*P) (*P, error) {
+	if len(ps) == 0 {
+		return nil, errors.New("no patterns to combine")
+	}
+	for i, p := range ps {
+		if p == nil {
+			return nil, errors.New("nil pattern at index " + strconv.Itoa(i))
+		} else if p.alts != nil {
+			return nil, errors.New("nested alternation at index " + strconv.Itoa(i))
+		} else if opt := p.wholeOption(); opt != "" {
+			return nil, fmt.Errorf("unsupported option %s at index %d", opt, i)
+		}
+	}
+	out := &P{alts: append([]*P(nil), ps...)}
+	if _, err := out.compileRegexp(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// wholeOption returns the name of an option of p that applies to a match as a
+// whole rather than to its parts, or "" if p has none. Any does not support
+// these options on its alternatives.
+func (p *P) wholeOption() string {
+	switch {
+	case p.loose&anchorEnd != 0:
+		return "AnchorStart"
+	case p.loose&anchorStart != 0:
+		return "AnchorEnd"
+	case p.noEmpty:
+		return "NoEmpty"
+	case p.same:
+		return "SameValue"
+	case p.fold:
+		return "UnicodeFold"
+	case p.space:
+		return "TrimSpace"
+	}
+	return ""
+}
+
+// errAny is reported by methods that require a template when called on a
+// pattern constructed by Any.
+var errAny = errors.New("pattern constructed by Any has no template")
+
+// altSource assembles the source of a regexp that matches any of the
+// alternatives of p. Each alternative is wrapped in an unnamed capture group
+// so that bindAlt can tell which one matched.
+func (p *P) altSource() (string, error) {
+	srcs := make([]string, len(p.alts))
+	for i, alt := range p.alts {
+		src, err := alt.regexpSource()
+		if err != nil {
+			return "", err
+		}
+		srcs[i] = "(" + src + ")"
+	}
+	return strings.Join(srcs, "|"), nil
+}
+
+// bindAlt extracts bindings from needle for the alternative of p that matched,
+// given the submatch indices in m. The capture groups for each alternative are
+// its own unnamed group, followed by the groups of its compiled regexp, whose
+// number may exceed the number of pattern words if it has InnerGroups.
+func bindAlt[S string | []byte](p *P, m []int, needle S) Binds {
+	g := 1
+	for i, alt := range p.alts {
+		n := alt.re.NumSubexp()
+		if m[2*g] < 0 {
+			g += n + 1
+			continue
+		}
+		binds := Binds{{Name: AnyWord, Expr: strconv.Itoa(i)}}
+		return append(binds, bindMatches(alt, m[2*g:2*(g+n+1)], needle)...)
+	}
+	return nil
+}
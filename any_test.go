@@ -0,0 +1,99 @@
+package pattern
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAny(t *testing.T) {
+	p, err := Any(
+		MustParse(`${x}+${y}`, Binds{{"x", `\d+`}, {"y", `\d+`}}),
+		MustParse(`${x}=${v}`, Binds{{"x", `[a-z]+`}, {"v", `\w+`}}),
+		MustParse(`[${x} ]`, Binds{{"x", `[^\]]+`}}).Trim("x"),
+	)
+	if err != nil {
+		t.Fatalf("Any failed: %v", err)
+	}
+
+	tests := []struct {
+		needle string
+		want   Binds
+	}{
+		{"1+2", Binds{{AnyWord, "0"}, {"x", "1"}, {"y", "2"}}},
+		{"k=v", Binds{{AnyWord, "1"}, {"x", "k"}, {"v", "v"}}},
+		{"[ q ]", Binds{{AnyWord, "2"}, {"x", "q"}}},
+	}
+	for _, test := range tests {
+		got, err := p.Match(test.needle)
+		if err != nil {
+			t.Errorf("Match %q failed: %v", test.needle, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Match %q: got %+v, want %+v", test.needle, got, test.want)
+		}
+	}
+	if got, err := p.Match("k+2"); err != ErrNoMatch {
+		t.Errorf("Match: got %+v, %v; want %v", got, err, ErrNoMatch)
+	}
+
+	var got []Binds
+	if err := p.Search("a=b, 3+4, [c ], 5+6", func(_, _ int, binds Binds) error {
+		got = append(got, binds)
+		return nil
+	}); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	want := []Binds{
+		{{AnyWord, "1"}, {"x", "a"}, {"v", "b"}},
+		{{AnyWord, "0"}, {"x", "3"}, {"y", "4"}},
+		{{AnyWord, "2"}, {"x", "c"}},
+		{{AnyWord, "0"}, {"x", "5"}, {"y", "6"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Search:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestAnyErrors(t *testing.T) {
+	ok := MustParse(`x`, nil)
+	nested, err := Any(ok)
+	if err != nil {
+		t.Fatalf("Any failed: %v", err)
+	}
+	tests := []struct {
+		desc string
+		ps   []*P
+	}{
+		{"Empty", nil},
+		{"Nil", []*P{ok, nil}},
+		{"Nested", []*P{ok, nested}},
+		{"Bad expression", []*P{ok, MustParse(`${a}`, Binds{{"a", "[bad"}})}},
+		{"AnchorStart", []*P{ok, MustParse(`x`, nil, AnchorStart())}},
+		{"AnchorEnd", []*P{MustParse(`x`, nil, AnchorEnd())}},
+		{"NoEmpty", []*P{MustParse(`x`, nil, NoEmpty())}},
+		{"SameValue", []*P{MustParse(`${x} ${x}`, nil, SameValue())}},
+		{"UnicodeFold", []*P{MustParse(`x`, nil, UnicodeFold())}},
+		{"TrimSpace", []*P{MustParse(`x`, nil, TrimSpace())}},
+	}
+	for _, test := range tests {
+		if p, err := Any(test.ps...); err == nil {
+			t.Errorf("Any %s: got %+v, wanted error", test.desc, p)
+		}
+	}
+
+	// A pattern constructed by Any has no template to apply.
+	if nested.IsStatic() {
+		t.Error("IsStatic: got true, want false")
+	}
+	if got, err := nested.Apply(nil); err == nil {
+		t.Errorf("Apply: got %q, wanted error", got)
+	}
+	if got, err := nested.ApplyFunc(EnvOrEmptyBindFunc("")); err == nil {
+		t.Errorf("ApplyFunc: got %q, wanted error", got)
+	}
+	if got, err := nested.ApplyValues(nil); err == nil {
+		t.Errorf("ApplyValues: got %q, wanted error", got)
+	}
+	if got, err := nested.MatchApprox("", 1); err == nil {
+		t.Errorf("MatchApprox: got %+v, wanted error", got)
+	}
+}
@@ -0,0 +1,210 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MatchApprox behaves like Match, but permits the literal text of the template
+// to differ from the corresponding text of needle by up to maxEdits character
+// insertions, deletions, and substitutions in total. The values captured for
+// pattern words must still match their bound expressions exactly. A negative
+// maxEdits is treated as zero.
+//
+// Where several alignments of needle to the template are possible, the one
+// requiring the fewest edits is chosen.
+//
+// The AnchorStart, AnchorEnd, SameValue, UnicodeFold, TrimSpace, and DotAll
+// options apply as they do for Match. If p has the AnchorStart option alone,
+// the match that ends latest among those with the fewest edits is chosen.
+// MatchApprox reports an error if p has the SeparatorClass option.
+//
+// Unlike Match, MatchApprox does not use a single compiled regexp. It aligns
+// the template to needle by dynamic programming, so its cost grows with the
+// cube of the length of needle in the worst case. It is intended for short
+// inputs such as individual log lines.
+func (p *P) MatchApprox(needle string, maxEdits int) (Binds, error) {
+	if p.alts != nil {
+		return nil, errAny
+	} else if p.sep != "" {
+		return nil, errors.New("approximate matching does not support SeparatorClass")
+	}
+	maxEdits = max(maxEdits, 0)
+	if p.space {
+		needle = strings.TrimSpace(needle)
+	}
+	var segs []segment
+	skip := make(map[int]int) // :: index of segOpen → index of its segClose
+	words := make(map[string]*regexp.Regexp)
+	negs := make(map[string]*regexp.Regexp)
+	open := -1
+	for seg := range p.segments() {
+		switch seg.kind {
+		case segOpen:
+			open = len(segs)
+		case segClose:
+			skip[open] = len(segs)
+		case segWord, segNeg:
+			m, anchor := words, `^(?:%s)$`
+			if seg.kind == segNeg {
+				m, anchor = negs, `^(?:%s)`
+			}
+			if _, ok := m[seg.text]; ok {
+				break
+			}
+			name := seg.text
+			if seg.kind == segWord {
+				name = p.groupWord(name)
+			}
+			rule, ok := p.rules[name]
+			if !ok {
+				return nil, fmt.Errorf("no binding for %q", name)
+			}
+			re, err := regexp.Compile(p.flagPrefix() + fmt.Sprintf(anchor, rule))
+			if err != nil {
+				return nil, fmt.Errorf("invalid expression for %q: %v", seg.text, err)
+			}
+			m[seg.text] = re
+		}
+		segs = append(segs, seg)
+	}
+
+	// Work in runes of the (folded) text so that edits count characters, but
+	// record the byte offset of each rune so that expressions can be matched
+	// against the text and captured values can be sliced from needle.
+	text, idx := p.foldNeedle(needle)
+	var runes []rune
+	var offs []int
+	for i, r := range text {
+		runes = append(runes, r)
+		offs = append(offs, i)
+	}
+	offs = append(offs, len(text))
+	n := len(runes)
+	orig := func(j int) int { // the offset in needle of runes[j]
+		if idx != nil {
+			return idx[offs[j]]
+		}
+		return offs[j]
+	}
+
+	// cost[k][j] is the fewest edits needed to align segs[:k] with runes[:j],
+	// or -1 if no such alignment exists. from[k][j] gives the values of k and
+	// j from which that alignment was reached. A conditional block may be
+	// skipped, so k does not always advance by one.
+	cost := make([][]int, len(segs)+1)
+	from := make([][][2]int, len(segs)+1)
+	for k := range cost {
+		cost[k] = make([]int, n+1)
+		from[k] = make([][2]int, n+1)
+		for j := range cost[k] {
+			cost[k][j] = -1
+		}
+	}
+	cost[0][0] = 0
+	if p.loose&anchorStart != 0 {
+		for j := range cost[0] {
+			cost[0][j] = 0
+		}
+	}
+	relax := func(k, j, c, pk, pj int) {
+		if c <= maxEdits && (cost[k][j] < 0 || c < cost[k][j]) {
+			cost[k][j] = c
+			from[k][j] = [2]int{pk, pj}
+		}
+	}
+	for k, seg := range segs {
+		lit := []rune(seg.text)
+		if p.fold && seg.kind == segLit {
+			lit = []rune(fold(seg.text))
+		}
+		for j := 0; j <= n; j++ {
+			c := cost[k][j]
+			if c < 0 {
+				continue
+			}
+			switch seg.kind {
+			case segLit:
+				end := min(n, j+len(lit)+maxEdits-c)
+				for e, d := range editDistances(lit, runes[j:end]) {
+					relax(k+1, j+e, c+d, k, j)
+				}
+			case segWord:
+				re := words[seg.text]
+				for e := j; e <= n; e++ {
+					if re.MatchString(text[offs[j]:offs[e]]) {
+						relax(k+1, e, c, k, j)
+					}
+				}
+			case segOpen:
+				relax(k+1, j, c, k, j)
+				relax(skip[k]+1, j, c, k, j)
+			case segClose:
+				relax(k+1, j, c, k, j)
+			case segNeg:
+				if !negs[seg.text].MatchString(text[offs[j]:]) {
+					relax(k+1, j, c, k, j)
+				}
+			}
+		}
+	}
+	last := cost[len(segs)]
+	end := n
+	if p.loose&anchorEnd != 0 {
+		for j := n - 1; j >= 0; j-- {
+			if last[j] >= 0 && (last[end] < 0 || last[j] < last[end]) {
+				end = j
+			}
+		}
+	}
+	if last[end] < 0 {
+		return nil, ErrNoMatch
+	}
+
+	// Walk the chosen alignment backward to recover the captured values, then
+	// report them in order as Match does.
+	var vals []Bind
+	for k, j := len(segs), end; k > 0; {
+		prev := from[k][j]
+		if seg := segs[prev[0]]; seg.kind == segWord {
+			vals = append(vals, Bind{Name: p.groupWord(seg.text), Expr: needle[orig(prev[1]):orig(j)]})
+		}
+		k, j = prev[0], prev[1]
+	}
+	var binds Binds
+	for i := len(vals) - 1; i >= 0; i-- {
+		name, val := vals[i].Name, vals[i].Expr
+		if p.trim[name] {
+			val = strings.TrimSpace(val)
+		}
+		if p.aliased(name) && binds.Has(name) && binds.First(name) != val {
+			return nil, ErrNoMatch
+		}
+		binds = p.appendBind(binds, name, val)
+	}
+	return p.checkSame(binds)
+}
+
+// editDistances returns a slice d in which d[i] is the edit distance between
+// lit and s[:i], for 0 ≤ i ≤ len(s).
+func editDistances(lit, s []rune) []int {
+	prev := make([]int, len(s)+1)
+	cur := make([]int, len(s)+1)
+	for i := range prev {
+		prev[i] = i
+	}
+	for a := 1; a <= len(lit); a++ {
+		cur[0] = a
+		for b := 1; b <= len(s); b++ {
+			sub := prev[b-1]
+			if lit[a-1] != s[b-1] {
+				sub++
+			}
+			cur[b] = min(prev[b]+1, cur[b-1]+1, sub)
+		}
+		prev, cur = cur, prev
+	}
+	return prev
+}
@@ -0,0 +1,88 @@
+package pattern
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchApprox(t *testing.T) {
+	p := MustParse(`user ${name} logged in from ${addr}`, Binds{
+		{"name", `\w+`}, {"addr", `[\d.]+`},
+	})
+	tests := []struct {
+		needle string
+		edits  int
+		want   Binds
+	}{
+		{"user bob logged in from 10.0.0.1", 0,
+			Binds{{"name", "bob"}, {"addr", "10.0.0.1"}}},
+		{"usr bob logged in from 10.0.0.1", 1,
+			Binds{{"name", "bob"}, {"addr", "10.0.0.1"}}},
+		{"user bob 1ogged im frum 10.0.0.1", 3,
+			Binds{{"name", "bob"}, {"addr", "10.0.0.1"}}},
+		{"user alice loged inn from 192.168.1.5", 2,
+			Binds{{"name", "alice"}, {"addr", "192.168.1.5"}}},
+	}
+	for _, test := range tests {
+		got, err := p.MatchApprox(test.needle, test.edits)
+		if err != nil {
+			t.Errorf("MatchApprox(%q, %d) failed: %v", test.needle, test.edits, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("MatchApprox(%q, %d): got %+v, want %+v", test.needle, test.edits, got, test.want)
+		}
+	}
+
+	for _, test := range []struct {
+		needle string
+		edits  int
+	}{
+		{"usr bob logged in from 10.0.0.1", 0},
+		{"user bob 1ogged im frum 10.0.0.1", 2},
+		{"user bob logged in from nowhere", 5},
+	} {
+		if got, err := p.MatchApprox(test.needle, test.edits); err != ErrNoMatch {
+			t.Errorf("MatchApprox(%q, %d): got %+v, %v; want %v", test.needle, test.edits, got, err, ErrNoMatch)
+		}
+	}
+}
+
+func TestMatchApproxOptions(t *testing.T) {
+	tests := []struct {
+		desc   string
+		p      *P
+		needle string
+		want   Binds // nil for no match
+	}{
+		{"SameValue", MustParse(`${x} ${x}`, Binds{{"x", `\w+`}}, SameValue()),
+			"ab ab", Binds{{"x", "ab"}, {"x", "ab"}}},
+		{"SameValue", MustParse(`${x} ${x}`, Binds{{"x", `\w+`}}, SameValue()),
+			"ab cd", nil},
+		{"UnicodeFold", MustParse(`hello ${who}`, Binds{{"who", `[a-z]+`}}, UnicodeFold()),
+			"HELO World", Binds{{"who", "World"}}},
+		{"TrimSpace", MustParse(`id=${n}`, Binds{{"n", `\d+`}}, TrimSpace()),
+			"  ib=12\n", Binds{{"n", "12"}}},
+		{"AnchorStart", MustParse(`id=${n}`, Binds{{"n", `\d+`}}, AnchorStart()),
+			"ib=12 and more", Binds{{"n", "12"}}},
+		{"AnchorStart", MustParse(`id=${n}`, Binds{{"n", `\d+`}}, AnchorStart()),
+			"xx ib=12", nil},
+		{"AnchorEnd", MustParse(`id=${n}`, Binds{{"n", `\d+`}}, AnchorEnd()),
+			"prefix ib=12", Binds{{"n", "12"}}},
+		{"AnchorEnd", MustParse(`id=${n}`, Binds{{"n", `\d+`}}, AnchorEnd()),
+			"ib=12 xx", nil},
+	}
+	for _, test := range tests {
+		got, err := test.p.MatchApprox(test.needle, 1)
+		if test.want == nil {
+			if err != ErrNoMatch {
+				t.Errorf("%s: MatchApprox(%q): got %+v, %v; want %v", test.desc, test.needle, got, err, ErrNoMatch)
+			}
+		} else if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: MatchApprox(%q): got %+v, %v; want %+v", test.desc, test.needle, got, err, test.want)
+		}
+	}
+
+	sep := MustParse(`a-${x}`, Binds{{"x", `\w`}}, SeparatorClass("-"))
+	if got, err := sep.MatchApprox("a-b", 1); err == nil || err == ErrNoMatch {
+		t.Errorf("SeparatorClass: got %+v, %v; want an unsupported error", got, err)
+	}
+}
@@ -0,0 +1,258 @@
+package pattern
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Precompile compiles all the regexps used by p to match, reporting an error
+// if any of them cannot be compiled. Otherwise, regexps are compiled when p is
+// first used to match, which is not safe for concurrent use. After a
+// successful call to Precompile, p may be used concurrently by multiple
+// goroutines to match.
+func (p *P) Precompile() error {
+	if _, err := p.compileMatch(); err != nil {
+		return err
+	} else if _, err := p.compileWords(); err != nil {
+		return err
+	}
+	_, err := p.compileLines()
+	return err
+}
+
+// binaryVersion is the version of the encoding used by MarshalBinary.
+// Version 2 added the characters permitted in names by NameRunes.
+const binaryVersion = 2
+
+// Flag bits for the options of a pattern in its binary encoding.
+const (
+	binAnchorMask = 0x03 // the sides of the needle in P.loose
+	binNoEmpty    = 0x04
+	binSame       = 0x08
+	binFold       = 0x10
+	binInner      = 0x20
+	binSpace      = 0x40
+	binDotAll     = 0x80
+	binEmptyMask  = 0x300 // the mode given to AllowEmptyWord
+	binEmptyShift = 8
+)
+
+// MarshalBinary implements the encoding.BinaryMarshaler interface. The
+// encoding records the template of p, the expressions bound to its pattern
+// words, and the options given to Parse, but not any compiled state.
+// A pattern constructed by Any cannot be encoded.
+//
+// The encoding begins with a version byte, followed by the option flags and
+// a sequence of length-prefixed strings, so it is compact and can be
+// extended in later versions while still decoding older ones.
+func (p *P) MarshalBinary() ([]byte, error) {
+	if p.alts != nil {
+		return nil, errors.New("cannot encode a pattern constructed by Any")
+	}
+	buf := []byte{binaryVersion}
+	buf = binary.AppendUvarint(buf, p.binFlags())
+	buf = appendString(buf, p.template)
+	buf = appendString(buf, p.sep)
+	buf = appendString(buf, p.extra)
+
+	names := make([]string, 0, len(p.rules))
+	for name := range p.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	buf = binary.AppendUvarint(buf, uint64(len(names)))
+	for _, name := range names {
+		buf = appendString(appendString(buf, name), p.rules[name])
+	}
+
+	var trim []string
+	for name, ok := range p.trim {
+		if ok {
+			trim = append(trim, name)
+		}
+	}
+	sort.Strings(trim)
+	buf = binary.AppendUvarint(buf, uint64(len(trim)))
+	for _, name := range trim {
+		buf = appendString(buf, name)
+	}
+
+	buf = binary.AppendUvarint(buf, uint64(len(p.alias)))
+	for _, pair := range p.alias {
+		buf = appendString(appendString(buf, pair[0]), pair[1])
+	}
+	return buf, nil
+}
+
+// binFlags returns the flag bits for the options of p in its binary encoding.
+func (p *P) binFlags() uint64 {
+	flags := uint64(p.loose) | uint64(p.empty)<<binEmptyShift
+	for _, f := range []struct {
+		set bool
+		bit uint64
+	}{
+		{p.noEmpty, binNoEmpty}, {p.same, binSame}, {p.fold, binFold},
+		{p.inner, binInner}, {p.space, binSpace}, {p.dotAll, binDotAll},
+	} {
+		if f.set {
+			flags |= f.bit
+		}
+	}
+	return flags
+}
+
+// Hash returns a 64-bit FNV-1a hash of the structure of p, covering what
+// Equivalent compares: the literal text and pattern words of the template,
+// the conditional blocks and negative words, the expression, trimming, and
+// laziness of each word, and the options given to Parse. Equivalent patterns
+// have equal hashes, regardless of how their templates were written or the
+// order in which their bindings were given. For a pattern constructed by Any,
+// the hash combines the hashes of the alternatives in order.
+//
+// The hash is stable across runs of a program, but may change in a later
+// version of this package.
+func (p *P) Hash() uint64 {
+	h := fnv.New64a()
+	if p.alts != nil {
+		var buf []byte
+		for _, alt := range p.alts {
+			buf = binary.BigEndian.AppendUint64(buf, alt.Hash())
+		}
+		h.Write(buf)
+		return h.Sum64()
+	}
+	buf := binary.AppendUvarint(nil, p.binFlags())
+	buf = appendString(buf, p.sep)
+	buf = appendString(buf, p.extra)
+	buf = binary.AppendUvarint(buf, uint64(len(p.parts)))
+	for i, part := range p.parts {
+		buf = appendString(buf, part)
+		if i%2 == 1 {
+			buf = appendString(buf, p.rules[part])
+			buf = append(buf, boolByte(p.trim[part]), boolByte(p.lazy[part]))
+		}
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(p.marks)))
+	for _, m := range p.marks {
+		buf = binary.AppendUvarint(buf, uint64(m.kind))
+		buf = appendString(buf, m.name)
+		buf = binary.AppendUvarint(buf, uint64(m.pos[0]))
+		buf = binary.AppendUvarint(buf, uint64(m.pos[1]))
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(p.alias)))
+	for _, pair := range p.alias {
+		buf = appendString(appendString(buf, pair[0]), pair[1])
+	}
+	h.Write(buf)
+	return h.Sum64()
+}
+
+// boolByte returns 1 if b is true, otherwise 0.
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// UnmarshalBinary implements the encoding.BinaryUnmarshaler interface. It
+// replaces the contents of p with the pattern encoded by data, as produced by
+// MarshalBinary. The regexps of the result are not compiled until it is used
+// to match, or Precompile is called.
+func (p *P) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty pattern encoding")
+	}
+	version := data[0]
+	if version < 1 || version > binaryVersion {
+		return fmt.Errorf("unsupported pattern encoding version %d", version)
+	}
+	d := &decoder{buf: data[1:]}
+	flags := d.uvarint()
+	template, sep := d.string(), d.string()
+	var extra string
+	if version >= 2 {
+		extra = d.string()
+	}
+	var binds Binds
+	for n := d.count(); n > 0; n-- {
+		binds = append(binds, Bind{Name: d.string(), Expr: d.string()})
+	}
+	var trim []string
+	for n := d.count(); n > 0; n-- {
+		trim = append(trim, d.string())
+	}
+	opts := []Option{NameRunes(extra), AllowEmptyWord(EmptyWord(flags & binEmptyMask >> binEmptyShift))}
+	for n := d.count(); n > 0; n-- {
+		opts = append(opts, Alias(d.string(), d.string()))
+	}
+	if d.err != nil {
+		return d.err
+	} else if len(d.buf) != 0 {
+		return errors.New("extra data after pattern encoding")
+	}
+
+	q, err := Parse(template, binds, opts...)
+	if err != nil {
+		return err
+	}
+	q = q.Trim(trim...)
+	q.loose = int(flags & binAnchorMask)
+	q.noEmpty = flags&binNoEmpty != 0
+	q.same = flags&binSame != 0
+	q.fold = flags&binFold != 0
+	q.inner = flags&binInner != 0
+	q.space = flags&binSpace != 0
+	q.dotAll = flags&binDotAll != 0
+	q.sep = sep
+	*p = *q
+	return nil
+}
+
+// appendString appends the length-prefixed encoding of s to buf.
+func appendString(buf []byte, s string) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+// A decoder reads values from the binary encoding of a pattern. The first
+// error encountered is recorded in err, after which all reads return zero.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errors.New("invalid pattern encoding")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count reads a count of items, each of which occupies at least one byte.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("invalid pattern encoding")
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
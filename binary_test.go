@@ -0,0 +1,117 @@
+package pattern
+
+import (
+	"encoding"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*P)(nil)
+	_ encoding.BinaryUnmarshaler = (*P)(nil)
+)
+
+func TestBinary(t *testing.T) {
+	tests := []*P{
+		MustParse("", nil),
+		MustParse("static $$5", nil),
+		MustParse(`${a}-${b?} ${c}`, Binds{{"a", `\d+`}, {"b", `\w+`}}),
+		MustParse(`x ${a} y`, Binds{{"a", `\d+`}}).Trim("a"),
+		MustParse(`${a}=${b}${?c:!}`, Binds{{"a", `\w+`}, {"b", `\w+`}},
+			AnchorStart(), NoEmpty(), SameValue(), UnicodeFold(), InnerGroups(),
+			SeparatorClass(" -"), Alias("a", "z"), TrimSpace(), DotAll()),
+		Literal("${not a word}"),
+		MustParse(`${user.name}@${host}`, nil, NameRunes(".")),
+		MustParse(`${}${x}`, nil, AllowEmptyWord(EmptyWordLiteral)),
+		MustParse(`${}${x}`, nil, AllowEmptyWord(EmptyWordSkip)),
+	}
+	for _, p := range tests {
+		data, err := p.MarshalBinary()
+		if err != nil {
+			t.Errorf("MarshalBinary %q failed: %v", p, err)
+			continue
+		}
+		var q P
+		if err := q.UnmarshalBinary(data); err != nil {
+			t.Errorf("UnmarshalBinary %q failed: %v", p, err)
+			continue
+		}
+		if !q.Equivalent(p) || q.String() != p.String() {
+			t.Errorf("Round trip %q: got %q, not equivalent", p, q.String())
+		}
+		if err := q.Precompile(); err != nil {
+			t.Errorf("Precompile %q failed: %v", p, err)
+		}
+	}
+
+	data, _ := tests[2].MarshalBinary()
+	for _, bad := range [][]byte{nil, {0}, {binaryVersion + 1}, data[:len(data)-1], append(data, 0)} {
+		var q P
+		if err := q.UnmarshalBinary(bad); err == nil {
+			t.Errorf("UnmarshalBinary %q: got %q, want error", bad, q.String())
+		}
+	}
+
+	// Version 1 encodings, which do not record NameRunes, are still accepted.
+	v1 := []byte{1, 0, 4, '$', '{', 'x', '}', 0, 1, 1, 'x', 2, '\\', 'd', 0, 0}
+	var q P
+	if err := q.UnmarshalBinary(v1); err != nil {
+		t.Errorf("UnmarshalBinary version 1 failed: %v", err)
+	} else if m, err := q.Match("5"); err != nil || m.First("x") != "5" {
+		t.Errorf("Match version 1: got %+v, %v; want x=5", m, err)
+	}
+
+	if alt, err := Any(tests[1], tests[2]); err != nil {
+		t.Errorf("Any failed: %v", err)
+	} else if data, err := alt.MarshalBinary(); err == nil {
+		t.Errorf("MarshalBinary Any: got %q, want error", data)
+	}
+	if err := MustParse(`${x}`, Binds{{"x", `(`}}).Precompile(); err == nil {
+		t.Error("Precompile invalid: got nil, want error")
+	}
+}
+
+func TestHash(t *testing.T) {
+	const template = `${a}-${b}`
+	p := MustParse(template, Binds{{"a", `\d+`}, {"b", `\w+`}})
+	q := MustParse(template, Binds{{"b", `\w+`}, {"a", `\d+`}})
+	if p.Hash() != q.Hash() {
+		t.Errorf("Hash of equivalent patterns differs: %x vs. %x", p.Hash(), q.Hash())
+	}
+	if p.Hash() != p.Hash() {
+		t.Error("Hash is not deterministic")
+	}
+
+	others := []*P{
+		MustParse(template, Binds{{"a", `\d+`}, {"b", `\d+`}}),
+		MustParse(`${a}+${b}`, Binds{{"a", `\d+`}, {"b", `\w+`}}),
+		MustParse(template, Binds{{"a", `\d+`}, {"b", `\w+`}}, AnchorStart()),
+		MustParse(template, Binds{{"a", `\d+`}, {"b", `\w+`}}).Trim("a"),
+		MustParse(`${a}-${b?}`, Binds{{"a", `\d+`}, {"b", `\w+`}}),
+		MustParse(`${a}-${?b:${b}}`, Binds{{"a", `\d+`}, {"b", `\w+`}}),
+	}
+	for _, o := range others {
+		if o.Hash() == p.Hash() {
+			t.Errorf("Hash of %q matches unrelated pattern", o)
+		}
+	}
+
+	ab, _ := Any(p, others[0])
+	ba, _ := Any(others[0], p)
+	if ab.Hash() == ba.Hash() {
+		t.Error("Hash of Any does not depend on the order of alternatives")
+	}
+	if ab2, _ := Any(q, others[0]); ab2.Hash() != ab.Hash() {
+		t.Error("Hash of equivalent Any patterns differs")
+	}
+	// Equivalent patterns hash alike, however their templates are written.
+	for _, pair := range [][2]*P{
+		{MustParse(`${a\b}`, nil), MustParse(`${ab}`, nil)},
+		{MustParse(`$$${\a}`, Binds{{"a", `\d`}}), MustParse(`$$${a}`, Binds{{"a", `\d`}})},
+	} {
+		if !pair[0].Equivalent(pair[1]) {
+			t.Errorf("Equivalent %q, %q: got false, want true", pair[0], pair[1])
+		} else if pair[0].Hash() != pair[1].Hash() {
+			t.Errorf("Hash of equivalent %q and %q differs", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package pattern
+
+import "iter"
+
+// A mark records a position in the literal text of a template at which a
+// conditional block begins or ends, or a negative word occurs. The position
+// is given as an index in the parts of the pattern and a byte offset in that
+// part. An index equal to the number of parts denotes the end of the
+// template.
+type mark struct {
+	kind segKind // segOpen, segClose, or segNeg
+	name string  // the name of the condition or negative word
+	pos  [2]int
+}
+
+// A segKind identifies the kind of a segment of a template.
+type segKind int
+
+const (
+	segLit   segKind = iota // literal text
+	segWord                 // a pattern word
+	segOpen                 // the start of a conditional block
+	segClose                // the end of a conditional block
+	segNeg                  // a negative word
+)
+
+// A segment is a piece of a template, as reported by segments.
+type segment struct {
+	kind segKind
+	text string // the literal text, or the name of a word or condition
+}
+
+// segments returns an iterator over the segments of the template of p in
+// order of occurrence. Literal text is split at the boundaries of conditional
+// blocks and at negative words, and empty literals are omitted.
+func (p *P) segments() iter.Seq[segment] {
+	return func(yield func(segment) bool) {
+		k := 0
+		for i := 0; i <= len(p.parts); i++ {
+			if i%2 == 1 {
+				if i < len(p.parts) && !yield(segment{segWord, p.parts[i]}) {
+					return
+				}
+				continue
+			}
+			var text string
+			if i < len(p.parts) {
+				text = p.parts[i]
+			}
+			off := 0
+			for ; k < len(p.marks) && p.marks[k].pos[0] == i; k++ {
+				if at := p.marks[k].pos[1]; at > off {
+					if !yield(segment{segLit, text[off:at]}) {
+						return
+					}
+					off = at
+				}
+				if !yield(segment{p.marks[k].kind, p.marks[k].name}) {
+					return
+				}
+			}
+			if off < len(text) && !yield(segment{segLit, text[off:]}) {
+				return
+			}
+		}
+	}
+}
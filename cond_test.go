@@ -0,0 +1,314 @@
+package pattern
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConditional(t *testing.T) {
+	p := MustParse(`hello${?name:, ${name}}!${?bang: $}}`, Binds{{"name", `\w+`}})
+
+	t.Run("Apply", func(t *testing.T) {
+		tests := []struct {
+			binds Binds
+			want  string
+		}{
+			{Binds{{"name", "world"}}, "hello, world!"},
+			{Binds{{"name", ""}}, "hello!"},
+			{Binds{{"name", "you"}, {"bang", "y"}}, "hello, you! }"},
+			{Binds{{"bang", "y"}}, "hello! }"},
+			{nil, "hello!"},
+		}
+		for _, test := range tests {
+			got, err := p.Apply(test.binds)
+			if err != nil {
+				t.Errorf("Apply %+v failed: %v", test.binds, err)
+			} else if got != test.want {
+				t.Errorf("Apply %+v: got %q, want %q", test.binds, got, test.want)
+			}
+		}
+	})
+
+	t.Run("ApplyFunc", func(t *testing.T) {
+		got, err := p.ApplyFunc(func(name string, n int) (string, error) {
+			if name == "name" {
+				return fmt.Sprintf("%s%d", name, n), nil
+			}
+			return "", nil
+		})
+		if want := "hello, name1!"; err != nil || got != want {
+			t.Errorf("ApplyFunc: got %q, %v; want %q", got, err, want)
+		}
+	})
+
+	t.Run("Match", func(t *testing.T) {
+		tests := []struct {
+			needle string
+			want   string // value of name, or "" if absent
+			ok     bool
+		}{
+			{"hello!", "", true},
+			{"hello, world!", "world", true},
+			{"hello, world! }", "world", true},
+			{"hello! }", "", true},
+			{"hello, !", "", false},
+			{"hello world!", "", false},
+		}
+		for _, test := range tests {
+			got, err := p.Match(test.needle)
+			if !test.ok {
+				if err != ErrNoMatch {
+					t.Errorf("Match %q: got %+v, %v; want %v", test.needle, got, err, ErrNoMatch)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Match %q failed: %v", test.needle, err)
+			} else if v := got.First("name"); v != test.want {
+				t.Errorf("Match %q: got name=%q, want %q", test.needle, v, test.want)
+			} else if test.want == "" && got != nil {
+				t.Errorf("Match %q: got %#v, want nil", test.needle, got)
+			}
+		}
+	})
+
+	t.Run("ApplyValues", func(t *testing.T) {
+		for _, test := range []struct {
+			vals []string
+			want string
+		}{
+			{[]string{"world"}, "hello, world!"},
+			{[]string{""}, "hello!"},
+		} {
+			if got, err := p.ApplyValues(test.vals); err != nil || got != test.want {
+				t.Errorf("ApplyValues %q: got %q, %v; want %q", test.vals, got, err, test.want)
+			}
+		}
+	})
+
+	t.Run("ApplyFuncTrim", func(t *testing.T) {
+		for _, test := range []struct {
+			name string
+			keep bool
+			want string
+		}{
+			{"you", true, "hello, you!"},
+			{"", true, "hello!"},
+			{"you", false, "hello!"},
+		} {
+			got, err := p.ApplyFuncTrim(func(name string, _ int) (string, bool, error) {
+				if name == "name" {
+					return test.name, test.keep, nil
+				}
+				return "", true, nil
+			})
+			if err != nil || got != test.want {
+				t.Errorf("ApplyFuncTrim %q, %v: got %q, %v; want %q", test.name, test.keep, got, err, test.want)
+			}
+		}
+	})
+
+	t.Run("MatchApprox", func(t *testing.T) {
+		for _, test := range []struct {
+			needle string
+			edits  int
+			want   string // value of name, or "" if absent
+		}{
+			{"hello!", 0, ""},
+			{"hello, world!", 0, "world"},
+			{"hello, world! }", 0, "world"},
+			{"helo, world!", 1, "world"},
+			{"hallo!", 1, ""},
+		} {
+			got, err := p.MatchApprox(test.needle, test.edits)
+			if err != nil {
+				t.Errorf("MatchApprox %q failed: %v", test.needle, err)
+			} else if v := got.First("name"); v != test.want {
+				t.Errorf("MatchApprox %q: got name=%q, want %q", test.needle, v, test.want)
+			}
+		}
+		if got, err := p.MatchApprox("hello, !", 0); err != ErrNoMatch {
+			t.Errorf("MatchApprox empty block: got %+v, %v; want %v", got, err, ErrNoMatch)
+		}
+	})
+
+	t.Run("Any", func(t *testing.T) {
+		alt, err := Any(MustParse(`a${?x:=${x}}`, Binds{{"x", `\d+`}}), MustParse(`b${y}`, Binds{{"y", `\w`}}))
+		if err != nil {
+			t.Fatalf("Any failed: %v", err)
+		}
+		for _, test := range []struct {
+			needle string
+			want   Binds
+		}{
+			{"a", Binds{{AnyWord, "0"}}},
+			{"a=12", Binds{{AnyWord, "0"}, {"x", "12"}}},
+			{"bq", Binds{{AnyWord, "1"}, {"y", "q"}}},
+		} {
+			if got, err := alt.Match(test.needle); err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Match %q: got %+v, %v; want %+v", test.needle, got, err, test.want)
+			}
+		}
+	})
+
+	t.Run("Derive", func(t *testing.T) {
+		q, err := p.Derive(`${?name:[${name}]}`)
+		if err != nil {
+			t.Fatalf("Derive failed: %v", err)
+		}
+		for _, test := range []struct {
+			binds Binds
+			want  string
+		}{
+			{Binds{{"name", "x"}}, "[x]"},
+			{nil, ""},
+		} {
+			if got, err := q.Apply(test.binds); err != nil || got != test.want {
+				t.Errorf("Apply %+v: got %q, %v; want %q", test.binds, got, err, test.want)
+			}
+		}
+	})
+}
+
+func TestNegativeWord(t *testing.T) {
+	p := MustParse(`id=${!bad}${id}`, Binds{{"bad", `admin`}, {"id", `\w+`}}, AnchorStart(), AnchorEnd())
+
+	t.Run("Match", func(t *testing.T) {
+		tests := []struct {
+			needle string
+			ok     bool
+		}{
+			{"id=alice", true},
+			{"id=bob", true},
+			{"id=admin", false},
+			{"id=administrator", false},
+			{"id=sysadmin", true},
+		}
+		for _, test := range tests {
+			got, err := p.Match(test.needle)
+			if !test.ok {
+				if err != ErrNoMatch {
+					t.Errorf("Match %q: got %+v, %v; want %v", test.needle, got, err, ErrNoMatch)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("Match %q failed: %v", test.needle, err)
+			} else if len(got) != 1 || got[0].Name != "id" {
+				t.Errorf("Match %q: got %+v, want only id", test.needle, got)
+			}
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		q := MustParse(`id=${!bad}${id}`, Binds{{"bad", `admin`}, {"id", `\w+`}})
+		var got []string
+		if err := q.Search("id=admin id=alice id=root", func(_, _ int, binds Binds) error {
+			got = append(got, binds.First("id"))
+			return nil
+		}); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if want := "alice root"; strings.Join(got, " ") != want {
+			t.Errorf("Search: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		got, err := p.Apply(Binds{{"id", "carol"}})
+		if want := "id=carol"; err != nil || got != want {
+			t.Errorf("Apply: got %q, %v; want %q", got, err, want)
+		}
+	})
+
+	t.Run("Dump", func(t *testing.T) {
+		if got := p.Dump(); !strings.Contains(got, "NOT bad=admin") {
+			t.Errorf("Dump: got %q, want NOT bad=admin", got)
+		}
+	})
+
+	t.Run("Any", func(t *testing.T) {
+		alt, err := Any(
+			MustParse(`a${!bad}${x}`, Binds{{"bad", `z`}, {"x", `\w`}}),
+			MustParse(`b${y}`, Binds{{"y", `\w`}}),
+		)
+		if err != nil {
+			t.Fatalf("Any failed: %v", err)
+		}
+		for _, test := range []struct {
+			needle string
+			want   Binds // nil for no match
+		}{
+			{"ay", Binds{{AnyWord, "0"}, {"x", "y"}}},
+			{"az", nil},
+			{"bq", Binds{{AnyWord, "1"}, {"y", "q"}}},
+		} {
+			got, err := alt.Match(test.needle)
+			if test.want == nil {
+				if err != ErrNoMatch {
+					t.Errorf("Match %q: got %+v, %v; want %v", test.needle, got, err, ErrNoMatch)
+				}
+			} else if err != nil || !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Match %q: got %+v, %v; want %+v", test.needle, got, err, test.want)
+			}
+		}
+
+		var got []string
+		if err := alt.Search("az ay bq", func(_, _ int, binds Binds) error {
+			got = append(got, binds[len(binds)-1].Expr)
+			return nil
+		}); err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if want := "y q"; strings.Join(got, " ") != want {
+			t.Errorf("Search: got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("BadExpr", func(t *testing.T) {
+		// A failure to compile is reported on every call, not just the first.
+		q := MustParse(`a${!x}${y}`, Binds{{"x", `[bad`}, {"y", `\w*`}})
+		for i := range 2 {
+			if got, err := q.Match("ab"); err == nil {
+				t.Errorf("Match %d: got %+v, want error", i+1, got)
+			}
+			if err := q.Precompile(); err == nil {
+				t.Errorf("Precompile %d: got nil, want error", i+1)
+			}
+		}
+	})
+
+	t.Run("UnicodeFold", func(t *testing.T) {
+		q := MustParse(`foo${!x}${y}`, Binds{{"x", `bar`}, {"y", `.*`}}, UnicodeFold())
+		for _, needle := range []string{"foobar", "FOOBAR", "FooBAR"} {
+			if got, err := q.Match(needle); err != ErrNoMatch {
+				t.Errorf("Match %q: got %+v, %v; want %v", needle, got, err, ErrNoMatch)
+			}
+		}
+		if got, err := q.Match("FOOBAZ"); err != nil || !reflect.DeepEqual(got, Binds{{"y", "BAZ"}}) {
+			t.Errorf("Match: got %+v, %v; want [{y BAZ}]", got, err)
+		}
+		if n, err := q.Count("FOOBAR"); err != nil || n != 0 {
+			t.Errorf("Count: got %d, %v; want 0", n, err)
+		}
+	})
+
+	t.Run("EscapedName", func(t *testing.T) {
+		// A word whose name begins with "!" is distinct from a negative word.
+		q := MustParse(`${!y}${\!y}`, Binds{{"y", `a`}, {"!y", `\d`}})
+		if got, err := q.Match("5"); err != nil || !reflect.DeepEqual(got, Binds{{"!y", "5"}}) {
+			t.Errorf("Match: got %+v, %v; want [{!y 5}]", got, err)
+		}
+		if got, err := q.Match("a"); err != ErrNoMatch {
+			t.Errorf("Match: got %+v, %v; want %v", got, err, ErrNoMatch)
+		}
+	})
+
+	t.Run("BadName", func(t *testing.T) {
+		if _, err := Parse(`${!a?}`, nil); err == nil {
+			t.Error("Parse of invalid negative word did not fail")
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxExampleTries is the number of candidates Example generates before it
+// gives up on finding one that matches.
+const maxExampleTries = 100
+
+// Example generates a string that matches p, by synthesizing a random value
+// for each occurrence of a pattern word from the expression bound to it and
+// applying the values to the template. It returns the generated string along
+// with the bindings that were applied, in order of occurrence. If p was
+// parsed with SameValue, each word is given one value for all its
+// occurrences.
+//
+// Generation works from the structure of each expression, so unbounded
+// repetitions such as .* and \d+ are expanded only a few times, and the
+// characters of a class are chosen from printable ASCII when the class
+// permits. Expressions that rely on context, such as \b, or on the text of
+// neighbouring words, may yield values that do not match. Example checks each
+// candidate with Match and reports an error if it cannot find a match after a
+// bounded number of attempts.
+func (p *P) Example() (string, Binds, error) {
+	if p.alts != nil {
+		return "", nil, errors.New("cannot generate an example for an alternation")
+	}
+	exprs := make(map[string]*syntax.Regexp)
+	for i := 1; i < len(p.parts); i += 2 {
+		name := p.groupWord(p.parts[i])
+		if _, ok := exprs[name]; ok {
+			continue
+		}
+		rule, ok := p.rules[name]
+		if !ok {
+			return "", nil, fmt.Errorf("no binding for %q", name)
+		}
+		re, err := syntax.Parse(rule, p.syntaxFlags())
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid expression for %q: %v", name, err)
+		}
+		exprs[name] = re.Simplify()
+	}
+	for range maxExampleTries {
+		binds := p.Binds()
+		seen := make(map[string]string)
+		for i, b := range binds {
+			group := p.groupWord(b.Name)
+			if v, ok := seen[group]; ok && (p.same || p.aliased(group)) {
+				binds[i].Expr = v
+				continue
+			}
+			var buf strings.Builder
+			generate(&buf, exprs[group])
+			binds[i].Expr = buf.String()
+			seen[group] = binds[i].Expr
+		}
+		s, err := p.Apply(binds)
+		if err != nil {
+			return "", nil, err
+		}
+		if _, err := p.Match(s); err == nil {
+			return s, binds, nil
+		}
+	}
+	return "", nil, fmt.Errorf("no matching example found after %d attempts", maxExampleTries)
+}
+
+// maxExampleRepeat is the number of extra repetitions generate may produce
+// for a repetition operator beyond its minimum.
+const maxExampleRepeat = 3
+
+// generate writes to buf a random string matched by re.
+func generate(buf *strings.Builder, re *syntax.Regexp) {
+	switch re.Op {
+	case syntax.OpLiteral:
+		buf.WriteString(string(re.Rune))
+	case syntax.OpCharClass:
+		buf.WriteRune(pickRune(re.Rune))
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		buf.WriteRune(rune('a' + rand.IntN(26)))
+	case syntax.OpCapture:
+		generate(buf, re.Sub[0])
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			generate(buf, sub)
+		}
+	case syntax.OpAlternate:
+		generate(buf, re.Sub[rand.IntN(len(re.Sub))])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		lo, hi := 0, maxExampleRepeat
+		switch re.Op {
+		case syntax.OpPlus:
+			lo, hi = 1, 1+maxExampleRepeat
+		case syntax.OpQuest:
+			hi = 1
+		case syntax.OpRepeat:
+			lo, hi = re.Min, re.Max
+			if hi < 0 {
+				hi = lo + maxExampleRepeat
+			}
+		}
+		for range lo + rand.IntN(hi-lo+1) {
+			generate(buf, re.Sub[0])
+		}
+	}
+	// Other operators match only the empty string.
+}
+
+// pickRune returns a random rune from the class given by ranges, a sequence
+// of inclusive lo, hi pairs. Printable ASCII is preferred when the class
+// contains any.
+func pickRune(ranges []rune) rune {
+	var ascii []rune
+	for i := 0; i < len(ranges); i += 2 {
+		for c := max(ranges[i], ' '); c <= min(ranges[i+1], '~'); c++ {
+			ascii = append(ascii, c)
+		}
+	}
+	if len(ascii) != 0 {
+		return ascii[rand.IntN(len(ascii))]
+	}
+	if len(ranges) == 0 {
+		return utf8.RuneError
+	}
+	i := 2 * rand.IntN(len(ranges)/2)
+	c := ranges[i] + rand.Int32N(ranges[i+1]-ranges[i]+1)
+	if !utf8.ValidRune(c) || !unicode.IsPrint(c) {
+		return ranges[i]
+	}
+	return c
+}
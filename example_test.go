@@ -0,0 +1,62 @@
+package pattern
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestExample(t *testing.T) {
+	tests := []struct {
+		template string
+		binds    Binds
+		opts     []Option
+	}{
+		{"static text", nil, nil},
+		{"${a}-${b}", Binds{{"a", `\d{3}`}, {"b", `[a-f]+`}}, nil},
+		{"<${tag}>${body}</${tag}>", Binds{{"tag", `[a-z]+`}, {"body", `[^<>]*`}}, []Option{SameValue()}},
+		{"${x}@${y}.com", Binds{{"x", `\w+(\.\w+)?`}, {"y", `(foo|bar|baz)`}}, nil},
+		{"[${any}]", Binds{{"any", `.*`}}, nil},
+		{"${n}${?n:!}", Binds{{"n", `\d?`}}, nil},
+	}
+	for _, test := range tests {
+		p := MustParse(test.template, test.binds, test.opts...)
+		for range 20 {
+			s, binds, err := p.Example()
+			if err != nil {
+				t.Fatalf("Example %q failed: %v", test.template, err)
+			}
+			got, err := p.Match(s)
+			if err != nil {
+				t.Errorf("Example %q: generated %q does not match: %v", test.template, s, err)
+			}
+			if len(binds) != len(p.Binds()) {
+				t.Errorf("Example %q: got %d bindings, want %d", test.template, len(binds), len(p.Binds()))
+			}
+			for _, b := range binds {
+				expr, _ := p.Expr(b.Name)
+				if !regexp.MustCompile(`^(?:` + expr + `)$`).MatchString(b.Expr) {
+					t.Errorf("Example %q: value %q for %q does not match %q", test.template, b.Expr, b.Name, expr)
+				}
+			}
+			if test.opts != nil && got.First("tag") != got.All("tag")[1] {
+				t.Errorf("Example %q: got %+v, want repeated values to agree", test.template, got)
+			}
+		}
+	}
+
+	// The occurrences of an alias group are given the same value.
+	alias := MustParse("${a}=${b}", Binds{{"a", `[0-9]{2}`}}, Alias("a", "b"))
+	for range 20 {
+		s, _, err := alias.Example()
+		if err != nil {
+			t.Fatalf("Example alias failed: %v", err)
+		}
+		if got, err := alias.Match(s); err != nil || got.First("a") != got.First("b") {
+			t.Errorf("Example alias: generated %q gives %+v, %v", s, got, err)
+		}
+	}
+
+	if _, _, err := MustParse("${x}", Binds{{"x", `a\bb`}}).Example(); err == nil {
+		t.Error("Example with unsatisfiable expression: got nil, want error")
+	}
+}
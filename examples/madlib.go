@@ -45,17 +45,14 @@ func main() {
 	}
 
 	// Query the user for values to fill the bindings.
-	binds := pat.Binds()
-	for i, bind := range binds {
-		req := strings.Join(strings.Split(bind.Name, "_"), " ")
+	filled, err := pat.Fill(func(name string, i int) (string, error) {
+		req := strings.Join(strings.Split(name, "_"), " ")
 		rsp, err := prompt(fmt.Sprintf("(%d) %s", i+1, req))
 		if err != nil {
 			log.Fatalf("Input interrupted: %v", err)
 		}
-		binds[i].Expr = format(bind.Name, rsp)
-	}
-
-	filled, err := pat.Apply(binds)
+		return format(name, rsp), nil
+	})
 	if err != nil {
 		log.Fatalf("Filling lib: %v", err)
 	}
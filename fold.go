@@ -0,0 +1,110 @@
+package pattern
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// UnicodeFold is an option that makes Match and Search compare the needle
+// with the template under Unicode full case folding, as defined by the
+// CaseFolding.txt file of the Unicode Character Database without the Turkic
+// mappings. This is more thorough than the (?i) flag of an expression. For
+// example, under folding "ß" matches "SS", "ﬁ" matches "FI", and "ς" matches
+// "Σ". Both the needle and the literal text of the template
+// are folded before matching. The expressions bound to pattern words are
+// matched against the folded needle, so they should be written in lower case,
+// and should allow for combining marks, since some characters fold to a base
+// letter followed by a mark; for example "ῆ" folds to "η" and U+0342.
+//
+// Folding may change the length of the text. The offsets reported by Search
+// and the values captured for pattern words refer to the original needle. An
+// offset that falls within the folding of a single character is moved to the
+// start of that character.
+func UnicodeFold() Option { return func(o *options) { o.fold = true } }
+
+// foldString returns the case folding of s, along with a slice giving, for
+// each byte offset in the result, the corresponding offset in s. The slice
+// has one more element than the length of the result.
+func foldString(s string) (string, []int) {
+	var buf strings.Builder
+	idx := make([]int, 0, len(s)+1)
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		f := s[i : i+n]
+		if r != utf8.RuneError || n > 1 {
+			f = foldRune(r)
+		}
+		buf.WriteString(f)
+		for range len(f) {
+			idx = append(idx, i)
+		}
+		i += n
+	}
+	return buf.String(), append(idx, len(s))
+}
+
+// fold returns the case folding of s.
+func fold(s string) string {
+	f, _ := foldString(s)
+	return f
+}
+
+// foldRune returns the full case folding of r. Characters whose folding is
+// more than one character are listed in fullFold. Otherwise the folding is the
+// simple case folding of r, which is its lower case after mapping it to upper
+// case, except for the Turkic dotless ı, which folds to itself, and the
+// Cherokee letters, which fold to upper case.
+func foldRune(r rune) string {
+	if f, ok := fullFold[r]; ok {
+		return f
+	}
+	switch {
+	case r == 'ı':
+		return "ı"
+	case unicode.Is(unicode.Cherokee, r):
+		return string(unicode.ToUpper(r))
+	}
+	return string(unicode.ToLower(unicode.ToUpper(r)))
+}
+
+// foldNeedle returns the text to be matched for needle, and a slice mapping
+// offsets in that text back to offsets in needle, or nil if they are the same.
+func (p *P) foldNeedle(needle string) (string, []int) {
+	if !p.fold {
+		return needle, nil
+	}
+	return foldString(needle)
+}
+
+// findAll returns the indices of all the non-overlapping matches of re in
+// needle, as offsets in needle. Matches that fail the negative words or the
+// aliases of p are omitted.
+func (p *P) findAll(re *regexp.Regexp, needle string) [][]int {
+	text, idx := p.foldNeedle(needle)
+	ms := re.FindAllStringSubmatchIndex(text, -1)
+	out := ms[:0]
+	for _, m := range ms {
+		if !p.checkNeg(m, text) {
+			continue
+		}
+		if mapOffsets(m, idx); checkAlias(p, m, needle) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// mapOffsets replaces each nonnegative offset in m with the corresponding
+// element of idx. If idx == nil, m is not changed.
+func mapOffsets(m, idx []int) []int {
+	if idx != nil {
+		for i, v := range m {
+			if v >= 0 {
+				m[i] = idx[v]
+			}
+		}
+	}
+	return m
+}
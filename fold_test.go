@@ -0,0 +1,85 @@
+package pattern
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnicodeFold(t *testing.T) {
+	p := MustParse(`STRASSE ${n}, ${city}`, Binds{
+		{"n", `\d+`}, {"city", `[\pL\pM]+`},
+	}, UnicodeFold())
+
+	tests := []struct {
+		needle string
+		want   Binds
+	}{
+		{"STRASSE 5, Köln", Binds{{"n", "5"}, {"city", "Köln"}}},
+		{"straße 12, BERLIN", Binds{{"n", "12"}, {"city", "BERLIN"}}},
+		{"Straẞe 7, Ἀθῆναι", Binds{{"n", "7"}, {"city", "Ἀθῆναι"}}},
+	}
+	for _, test := range tests {
+		got, err := p.Match(test.needle)
+		if err != nil {
+			t.Errorf("Match %q failed: %v", test.needle, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Match %q: got %+v, want %+v", test.needle, got, test.want)
+		}
+	}
+	lig := MustParse(`FILE ${n}`, Binds{{"n", `\d+`}}, UnicodeFold())
+	if got, err := lig.Match("ﬁle 3"); err != nil || got.First("n") != "3" {
+		t.Errorf("Match ligature: got %+v, %v; want n=3", got, err)
+	}
+	if got, err := MustParse(`i`, nil, UnicodeFold()).Match("ı"); err != ErrNoMatch {
+		t.Errorf("Match dotless i: got %+v, %v; want %v", got, err, ErrNoMatch)
+	}
+	if got, err := p.Match("STRAS 5, Köln"); err != ErrNoMatch {
+		t.Errorf("Match: got %+v, %v; want %v", got, err, ErrNoMatch)
+	}
+	if _, err := MustParse(`STRASSE`, nil).Match("straße"); err != ErrNoMatch {
+		t.Errorf("Match without folding: got %v, want %v", err, ErrNoMatch)
+	}
+
+	// Offsets reported by Search refer to the original needle.
+	q := MustParse(`ss${x}`, Binds{{"x", `\d`}}, UnicodeFold())
+	const needle = "aß1 SS2 ß"
+	var got []string
+	if err := q.Search(needle, func(start, end int, binds Binds) error {
+		got = append(got, needle[start:end]+"|"+binds.First("x"))
+		return nil
+	}); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if want := []string{"ß1|1", "SS2|2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Search: got %+q, want %+q", got, want)
+	}
+}
+
+func TestFoldString(t *testing.T) {
+	tests := []struct {
+		input, want string
+	}{
+		{"", ""},
+		{"abc", "abc"},
+		{"ABC", "abc"},
+		{"Straße", "strasse"},
+		{"ΣΊΣΥΦΟΣ", "σίσυφοσ"},
+		{"İ", "i̇"},
+		{"ẞ", "ss"},
+		{"ﬁle", "file"},
+		{"ﬀ", "ff"},
+		{"ŉ", "ʼn"},
+		{"ı", "ı"},
+		{"ꭰᏸ", "ᎠᏰ"},
+		{"a\xffb", "a\xffb"},
+	}
+	for _, test := range tests {
+		got, idx := foldString(test.input)
+		if got != test.want {
+			t.Errorf("foldString(%q): got %q, want %q", test.input, got, test.want)
+		}
+		if len(idx) != len(got)+1 || idx[len(got)] != len(test.input) {
+			t.Errorf("foldString(%q): bad index %v", test.input, idx)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pattern
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// MatchInto matches needle against p, as Match, and on success stores the
+// first value bound to each pattern word into the corresponding field of the
+// struct pointed to by dst. A field corresponds to a pattern word if it has a
+// struct tag of the form
+//
+//	pattern:"name"
+//
+// Fields of string, bool, integer, and floating-point kind are supported;
+// values of non-string fields are parsed with the strconv package.
+//
+// It is an error if a tag names a word that does not occur in p, or if a word
+// of p has no corresponding field. If needle does not match p, MatchInto
+// returns ErrNoMatch. If any error is reported, dst is not modified.
+func (p *P) MatchInto(needle string, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return errors.New("destination must be a non-nil pointer to a struct")
+	}
+	v = v.Elem()
+
+	fields := make(map[string]reflect.Value) // :: pattern word → field
+	var names []string                       // pattern words in field order
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		name, ok := f.Tag.Lookup("pattern")
+		if !ok {
+			continue
+		} else if _, ok := p.rules[name]; !ok {
+			return fmt.Errorf("field %s: unknown pattern word %q", f.Name, name)
+		} else if _, ok := fields[name]; ok {
+			return fmt.Errorf("field %s: duplicate tag for %q", f.Name, name)
+		} else if !f.IsExported() {
+			return fmt.Errorf("field %s: unexported field for %q", f.Name, name)
+		}
+		fields[name] = v.FieldByIndex(f.Index)
+		names = append(names, name)
+	}
+	for i := 1; i < len(p.parts); i += 2 {
+		if _, ok := fields[p.parts[i]]; !ok {
+			return fmt.Errorf("no field for pattern word %q", p.parts[i])
+		}
+	}
+
+	binds, err := p.Match(needle)
+	if err != nil {
+		return err
+	}
+
+	// Convert all the values before storing any, so that a conversion error
+	// leaves dst unmodified.
+	vals := make([]reflect.Value, len(names))
+	for i, name := range names {
+		vals[i] = reflect.New(fields[name].Type()).Elem()
+		if err := setField(vals[i], binds.First(name)); err != nil {
+			return fmt.Errorf("setting %q: %w", name, err)
+		}
+	}
+	for i, name := range names {
+		fields[name].Set(vals[i])
+	}
+	return nil
+}
+
+// setField parses s according to the kind of f and stores the result in f.
+func setField(f reflect.Value, s string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		z, err := strconv.ParseInt(s, 0, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(z)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		z, err := strconv.ParseUint(s, 0, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(z)
+	case reflect.Float32, reflect.Float64:
+		z, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(z)
+	default:
+		return fmt.Errorf("unsupported field type %v", f.Type())
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package pattern
+
+import "testing"
+
+func TestMatchInto(t *testing.T) {
+	p := MustParse(`${host}:${port} up=${up} load=${load}`, Binds{
+		{"host", `[\w.]+`}, {"port", `\d+`}, {"up", `\w+`}, {"load", `[\d.]+`},
+	})
+
+	type status struct {
+		Host  string  `pattern:"host"`
+		Port  uint16  `pattern:"port"`
+		Up    bool    `pattern:"up"`
+		Load  float64 `pattern:"load"`
+		Other int
+	}
+	var got status
+	if err := p.MatchInto("example.com:8080 up=true load=0.75", &got); err != nil {
+		t.Fatalf("MatchInto failed: %v", err)
+	}
+	if want := (status{"example.com", 8080, true, 0.75, 0}); got != want {
+		t.Errorf("MatchInto: got %+v, want %+v", got, want)
+	}
+
+	if err := p.MatchInto("nonesuch", &got); err != ErrNoMatch {
+		t.Errorf("MatchInto: got %v, want %v", err, ErrNoMatch)
+	}
+	before := got
+	if err := p.MatchInto("h:99999 up=true load=1", &got); err == nil {
+		t.Error("MatchInto with out-of-range port: got nil, wanted error")
+	} else if got != before {
+		t.Errorf("MatchInto with out-of-range port: modified dst to %+v", got)
+	}
+	for range 10 { // map order once decided which fields were written
+		if err := p.MatchInto("h:1 up=maybe load=1", &got); err == nil {
+			t.Error("MatchInto with invalid bool: got nil, wanted error")
+		} else if got != before {
+			t.Fatalf("MatchInto with invalid bool: modified dst to %+v", got)
+		}
+	}
+
+	var missing struct {
+		Host string `pattern:"host"`
+	}
+	if err := p.MatchInto("h:1 up=true load=1", &missing); err == nil {
+		t.Error("MatchInto with missing fields: got nil, wanted error")
+	}
+	var unknown struct {
+		status
+		Bogus string `pattern:"bogus"`
+	}
+	if err := p.MatchInto("h:1 up=true load=1", &unknown); err == nil {
+		t.Error("MatchInto with unknown tag: got nil, wanted error")
+	}
+	if err := p.MatchInto("h:1 up=true load=1", got); err == nil {
+		t.Error("MatchInto with non-pointer: got nil, wanted error")
+	}
+}
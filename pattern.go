@@ -18,6 +18,36 @@
 // include a literal dollar sign, double it ($$); all other characters are
 // interpreted as written.
 //
+// Within the name of a pattern word, a backslash escapes the following
+// character, which is included in the name even if it is not otherwise
+// permitted; for example, ${a\ b} is the word "a b", and ${a\}} is "a}".
+//
+// A pattern word name may be followed by a question mark, as in ${name?}, to
+// indicate that its expression should match as few characters as possible
+// rather than as many as possible. The question mark is not part of the name.
+//
+// A conditional block has the format
+//
+//	${?name:text}
+//
+// where text is any template text, including pattern words, but not another
+// conditional block. Within the text, a literal "}" must be escaped as "$}".
+// When the template is applied, the text of the block is included only if the
+// value for the pattern word name is not empty; name need not occur elsewhere
+// in the template. When the template is matched, the text of the block is
+// optional.
+//
+// A negative word has the format
+//
+//	${!name}
+//
+// It matches an empty string, at a position where the expression bound to
+// name does not match a prefix of the remaining text, like a negative
+// lookahead. A negative word captures nothing, so it is not reported by Match
+// or Binds, and Apply ignores it. The check is made after the regexp has
+// matched, so a failed check rejects the match rather than causing other
+// ways of matching to be tried.
+//
 // # Matching
 //
 // Each pattern word is an anchor to a location in the template string.
@@ -27,11 +57,19 @@
 // To match a pattern against a string, use the Match method.  Match succeeds
 // if the string is a full regexp match for the expansion of the template with
 // the pattern word bindings. A successful match returns a list of Binds that
-// give the text of the submatches.
+// give the text of the submatches. Flags set within the expression bound to
+// a word, as in "(?i)foo", apply only to the text matched by that word.
+//
+// To match any of several patterns, combine them with Any.
 //
 // To find multiple matches of the pattern in the string, use the Search
 // method. Search behaves like Match, but invokes a callback for each complete,
-// non-overlapping match in sequence.
+// non-overlapping match in sequence. SearchLines is similar, but reports only
+// matches that span complete lines.
+//
+// To match a string that may contain small errors in the literal text of the
+// template, use the MatchApprox method. MatchApprox tolerates a bounded number
+// of character edits, at a much higher cost than Match.
 //
 // # Substitution
 //
@@ -45,9 +83,17 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"iter"
+	"os"
 	"regexp"
 	"regexp/syntax"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // P contains a compiled pattern.
@@ -57,12 +103,58 @@ type P struct {
 	parts    []string
 	template string            // the original template
 	rules    map[string]string // :: pattern word → regexp
+	trim     map[string]bool   // pattern words whose values are trimmed
+	lazy     map[string]bool   // pattern words matched non-greedily
 	re       *regexp.Regexp    // cache of compileRegexp
+	lines    *regexp.Regexp    // cache of compileLines
+	min      int               // cache of MinLen, valid when re != nil
+	names    []string          // cache of word names for the groups of re
+	alts     []*P              // alternatives, for a pattern constructed by Any
+	loose    int               // sides of the needle not anchored by Match
+	noEmpty  bool              // Search skips empty matches
+	same     bool              // Match requires repeated words to agree
+	sep      string            // separator characters that match as a class
+	fold     bool              // match under Unicode case folding
+	alias    [][2]string       // pairs of pattern words sharing a group
+	inner    bool              // report named groups within expressions
+	marks    []mark            // conditional blocks and negative words
+	dups     []string          // words given conflicting bindings by Parse
+	extra    string            // additional characters permitted in names
+	space    bool              // Match trims surrounding space from the needle
+	dotAll   bool              // "." in expressions matches newline
+	empty    EmptyWord         // treatment of empty pattern words by parse
+	match    *regexp.Regexp    // cache of compileMatch
+
+	negs  []*regexp.Regexp // cache of checks for negative words, by group of re
+	words []*regexp.Regexp // cache of compileWords
 }
 
 // String returns the original template string from which p was parsed.
 func (p *P) String() string { return p.template }
 
+// Expr reports whether name is a pattern word of p, and if so returns the
+// expression currently bound to it. The expression is "" if name is unbound.
+func (p *P) Expr(name string) (string, bool) {
+	expr, ok := p.rules[name]
+	return expr, ok
+}
+
+// Exprs returns a map from the name of each pattern word of p to the
+// expression currently bound to it. The result is a fresh copy, so modifying
+// it has no effect on p.
+func (p *P) Exprs() map[string]string {
+	out := make(map[string]string, len(p.rules))
+	for name, expr := range p.rules {
+		out[name] = expr
+	}
+	return out
+}
+
+// IsStatic reports whether the template of p contains no pattern words. A
+// static pattern matches only its own literal text, and Apply ignores any
+// bindings it is given. A pattern constructed by Any is not static.
+func (p *P) IsStatic() bool { return p.alts == nil && len(p.parts) <= 1 }
+
 // Binds returns a list of bindings for p, in parsed order, populated with the
 // currently-bound expression strings. Modifying the result has no effect on p,
 // the caller may use this to generate a list of bindings to fill with values.
@@ -75,160 +167,1620 @@ func (p *P) Binds() Binds {
 			Expr: p.rules[part],
 		})
 	}
-	return binds
+	return binds
+}
+
+// A WordPos gives the location of an occurrence of a pattern word in the
+// template of a pattern.
+type WordPos struct {
+	Name       string // the name of the pattern word
+	Start, End int    // the byte offsets of the word, from "$" through "}"
+}
+
+// WordOffsets returns the location of each occurrence of a pattern word in
+// the template of p, as reported by String, in order of occurrence. It
+// returns nil if p has no template, as for a pattern constructed by Any.
+func (p *P) WordOffsets() []WordPos {
+	t, err := parse(p.template, p.extra, p.empty)
+	if err != nil {
+		return nil
+	}
+	var out []WordPos
+	for i, name := range t.pat {
+		out = append(out, WordPos{Name: name, Start: t.spans[i][0], End: t.spans[i][1]})
+	}
+	return out
+}
+
+// Literal returns the literal text of the template of p, with all pattern
+// words removed. Escaped dollar signs are reported as a single "$".
+func (p *P) Literal() string {
+	var out strings.Builder
+	for i := 0; i < len(p.parts); i += 2 {
+		out.WriteString(p.parts[i])
+	}
+	return out.String()
+}
+
+// Format renders the template of p using open and close as the delimiters
+// for pattern words in place of "${" and "}". Lazy words, conditional blocks,
+// and negative words are rendered with their markers as in a template, using
+// open and close as their delimiters. In the literal text of the template,
+// each occurrence of the first character of open is escaped by doubling it,
+// and within a conditional block each occurrence of the first character of
+// close is escaped by preceding it with the first character of open. Thus
+// Format("${", "}") gives a template that parses to a pattern equivalent to
+// p, though it may escape names differently than the original template.
+func (p *P) Format(open, close string) string { return p.format(open, close, nil, nil) }
+
+// format implements Format and Highlight. If word != nil, the text of each
+// pattern word, negative word, and conditional block delimiter is passed
+// through it; if lit != nil, each run of literal text is passed through it.
+func (p *P) format(open, close string, word, lit func(string) string) string {
+	style := func(f func(string) string, s string) string {
+		if f == nil {
+			return s
+		}
+		return f(s)
+	}
+	var esc, escBlock *strings.Replacer
+	if r, _ := utf8.DecodeRuneInString(open); open != "" {
+		esc = strings.NewReplacer(string(r), string(r)+string(r))
+		if c, _ := utf8.DecodeRuneInString(close); close != "" && c != r {
+			escBlock = strings.NewReplacer(string(r), string(r)+string(r), string(c), string(r)+string(c))
+		}
+	}
+	var out strings.Builder
+	inBlock := false
+	for seg := range p.segments() {
+		switch seg.kind {
+		case segLit:
+			text := seg.text
+			if inBlock && escBlock != nil {
+				text = escBlock.Replace(text)
+			} else if esc != nil {
+				text = esc.Replace(text)
+			}
+			out.WriteString(style(lit, text))
+		case segWord:
+			name := p.quoteName(seg.text)
+			if p.lazy[seg.text] {
+				name += "?"
+			}
+			out.WriteString(style(word, open+name+close))
+		case segNeg:
+			out.WriteString(style(word, open+"!"+p.quoteName(seg.text)+close))
+		case segOpen:
+			// A colon ends the name of a condition, so it must be escaped.
+			name := strings.ReplaceAll(p.quoteName(seg.text), ":", `\:`)
+			out.WriteString(style(word, open+"?"+name+":"))
+			inBlock = true
+		case segClose:
+			out.WriteString(style(word, close))
+			inBlock = false
+		}
+	}
+	return out.String()
+}
+
+// Dump returns a description of the structure of p for diagnostic purposes.
+// Each run of literal text is rendered as LIT followed by the quoted text,
+// and each pattern word as WORD followed by its name, "=", and its bound
+// expression; a lazy word has "?" after its name. The start and end of a
+// conditional block are rendered as IF followed by its condition, and END.
+// For example, the template "foo${bar}baz" with bar bound to \d+ gives
+//
+//	LIT "foo" WORD bar=\d+ LIT "baz"
+//
+// A pattern constructed by Any is rendered as ANY followed by the dumps of
+// its alternatives, in parentheses and separated by "|".
+func (p *P) Dump() string {
+	var out []string
+	if p.alts != nil {
+		for _, alt := range p.alts {
+			out = append(out, alt.Dump())
+		}
+		return "ANY(" + strings.Join(out, " | ") + ")"
+	}
+	for seg := range p.segments() {
+		switch seg.kind {
+		case segLit:
+			out = append(out, "LIT "+strconv.Quote(seg.text))
+		case segOpen:
+			out = append(out, "IF "+seg.text)
+		case segClose:
+			out = append(out, "END")
+		case segNeg:
+			out = append(out, "NOT "+seg.text+"="+p.rules[seg.text])
+		default:
+			name := seg.text
+			if p.lazy[name] {
+				name += "?"
+			}
+			out = append(out, "WORD "+name+"="+p.rules[seg.text])
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// quoteName returns the pattern word name escaped as it must be written in a
+// template, with a backslash before each character not permitted in a name.
+func (p *P) quoteName(name string) string {
+	var buf strings.Builder
+	for _, c := range name {
+		if !isWordRune(c) && !strings.ContainsRune(p.extra, c) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(c)
+	}
+	return buf.String()
+}
+
+// Highlight renders the template of p, as Format("${", "}"), but passes the
+// text of each pattern word (including its delimiters) through colorWord and
+// each run of literal text through colorLit. The markers of negative words and
+// the delimiters of conditional blocks are styled as pattern words. Either
+// function may be nil to leave the corresponding text unstyled. Empty literals
+// are omitted.
+func (p *P) Highlight(colorWord, colorLit func(string) string) string {
+	return p.format("${", "}", colorWord, colorLit)
+}
+
+// Match reports whether needle matches p, and if so returns a list of bindings
+// for the pattern words occurring in s.  Because the same pattern word may
+// occur multiple times in the pattern, the order of bindings is significant.
+//
+// By default the match must span all of needle. If p was parsed with the
+// AnchorStart or AnchorEnd options, only the specified ends are required to
+// coincide with the ends of needle.
+//
+// If matching fails, Match returns nil, ErrNoMatch.
+// If matching succeeds but no bindings are found, Match returns nil, nil.
+func (p *P) Match(needle string) (Binds, error) {
+	re, err := p.compileMatch()
+	if err != nil {
+		return nil, err
+	}
+	if p.space {
+		needle = strings.TrimSpace(needle)
+	}
+	text, idx := p.foldNeedle(needle)
+	if len(text) < p.min {
+		return nil, ErrNoMatch
+	}
+	m := re.FindStringSubmatchIndex(text)
+	if !p.anchored(m, len(text)) {
+		return nil, ErrNoMatch
+	}
+	if !p.checkNeg(m, text) {
+		return nil, ErrNoMatch
+	}
+	if m = mapOffsets(m, idx); !checkAlias(p, m, needle) {
+		return nil, ErrNoMatch
+	}
+	return p.checkSame(bindMatches(p, m, needle))
+}
+
+// MatchAll matches each of needles against p, as Match, compiling p only
+// once. It returns slices parallel to needles giving the bindings and the
+// error for each needle; a needle that does not match has a nil binding and
+// the error ErrNoMatch.
+func (p *P) MatchAll(needles []string) ([]Binds, []error) {
+	binds := make([]Binds, len(needles))
+	errs := make([]error, len(needles))
+	if _, err := p.compileMatch(); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return binds, errs
+	}
+	for i, needle := range needles {
+		binds[i], errs[i] = p.Match(needle)
+	}
+	return binds, errs
+}
+
+// Matches reports whether needle matches p, as Match, discarding the bindings.
+// A needle that does not match is reported as false with a nil error, so
+// Matches reports an error only if p cannot be compiled.
+func (p *P) Matches(needle string) (bool, error) {
+	if _, err := p.Match(needle); err == ErrNoMatch {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MatchFunc behaves like Match, but passes the name and value of each binding
+// in the result through f, and replaces the value with the result.
+// MatchFunc will panic if f == nil.
+func (p *P) MatchFunc(needle string, f func(name, value string) string) (Binds, error) {
+	binds, err := p.Match(needle)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range binds {
+		binds[i].Expr = f(b.Name, b.Expr)
+	}
+	return binds, nil
+}
+
+// MatchIndexedNames behaves like Match, but each binding in the result is
+// named for its pattern word and its occurrence number among bindings of that
+// word, indexed from 1 and separated by "#". For example, if ${x} occurs
+// twice in p, the bindings are named "x#1" and "x#2".
+func (p *P) MatchIndexedNames(needle string) (Binds, error) {
+	binds, err := p.Match(needle)
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int) // :: name → index
+	for i, b := range binds {
+		index[b.Name]++
+		binds[i].Name = b.Name + "#" + strconv.Itoa(index[b.Name])
+	}
+	return binds, nil
+}
+
+// checkNeg reports whether the match m of p in needle satisfies the negative
+// words of p, meaning that the expression for each such word does not match
+// needle at the position of that word. If p uses case folding, needle and the
+// offsets in m must be those of the folded text.
+func (p *P) checkNeg(m []int, needle string) bool {
+	if len(p.negs) == 0 {
+		return true
+	}
+	for i, neg := range p.negs {
+		if neg != nil && m[2*i] >= 0 && neg.MatchString(needle[m[2*i]:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAlias reports whether the match m of p in needle gives the same text
+// for all the occurrences of each pattern word that is the target of an
+// alias, as required by Alias.
+func checkAlias[S string | []byte](p *P, m []int, needle S) bool {
+	if p.alts != nil {
+		g := 1
+		for _, alt := range p.alts {
+			n := alt.re.NumSubexp()
+			if m[2*g] >= 0 {
+				return checkAlias(alt, m[2*g:2*(g+n+1)], needle)
+			}
+			g += n + 1
+		}
+		return true
+	}
+	if len(p.alias) == 0 {
+		return true
+	}
+	seen := make(map[string]S)
+	for i, name := range p.names {
+		a, b := m[2*i], m[2*i+1]
+		if a < 0 || !p.aliased(name) {
+			continue
+		}
+		if old, ok := seen[name]; ok && string(old) != string(needle[a:b]) {
+			return false
+		}
+		seen[name] = needle[a:b]
+	}
+	return true
+}
+
+// hasAlias reports whether p, or any alternative of p, has aliases.
+func (p *P) hasAlias() bool {
+	return len(p.alias) != 0 || slices.ContainsFunc(p.alts, (*P).hasAlias)
+}
+
+// checkSame returns binds, or ErrNoMatch if p has the SameValue option and
+// binds gives different values for some pattern word.
+func (p *P) checkSame(binds Binds) (Binds, error) {
+	if p.same {
+		seen := make(map[string]string)
+		for _, b := range binds {
+			if old, ok := seen[b.Name]; ok && old != b.Expr {
+				return nil, ErrNoMatch
+			}
+			seen[b.Name] = b.Expr
+		}
+	}
+	return binds, nil
+}
+
+// anchored reports whether the submatch indices m describe a match that is
+// anchored, as required by p, to a needle of length n.
+func (p *P) anchored(m []int, n int) bool {
+	if m == nil {
+		return false
+	}
+	return (p.loose&anchorStart != 0 || m[0] == 0) && (p.loose&anchorEnd != 0 || m[1] == n)
+}
+
+// MatchBytes behaves like Match, but matches against a byte slice. Only the
+// captured values are copied out of needle.
+func (p *P) MatchBytes(needle []byte) (Binds, error) {
+	re, err := p.compileMatch()
+	if err != nil {
+		return nil, err
+	}
+	if p.fold || len(p.negs) != 0 {
+		return p.Match(string(needle))
+	}
+	if p.space {
+		needle = bytes.TrimSpace(needle)
+	}
+	if len(needle) < p.min {
+		return nil, ErrNoMatch
+	}
+	m := re.FindSubmatchIndex(needle)
+	if !p.anchored(m, len(needle)) || !checkAlias(p, m, needle) {
+		return nil, ErrNoMatch
+	}
+	return p.checkSame(bindMatches(p, m, needle))
+}
+
+// MatchReader behaves like MatchBytes, matching the complete contents of r.
+// Because the pattern must match all of its input, MatchReader reads r to the
+// end before matching. An error reading r is returned as-is.
+func (p *P) MatchReader(r io.Reader) (Binds, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return p.MatchBytes(data)
+}
+
+// MinLen returns a lower bound on the length in bytes of any string that
+// matches p. The bound counts the literal text of the template plus the
+// shortest match of each bound expression. An expression that cannot be
+// parsed contributes nothing to the bound. If MinLen is positive, every match
+// of p consumes at least one byte; otherwise use NoEmpty to make Search skip
+// empty matches.
+func (p *P) MinLen() int {
+	if p.alts != nil {
+		n := -1
+		for _, alt := range p.alts {
+			if m := alt.MinLen(); n < 0 || m < n {
+				n = m
+			}
+		}
+		return n
+	}
+	var n int
+	optional := false // inside a conditional block
+	for seg := range p.segments() {
+		switch {
+		case seg.kind == segOpen || seg.kind == segClose:
+			optional = seg.kind == segOpen
+		case optional || seg.kind == segNeg:
+			continue
+		case seg.kind == segLit:
+			n += p.minLiteralLen(seg.text)
+		default:
+			if s, err := syntax.Parse(p.rules[p.groupWord(seg.text)], p.syntaxFlags()); err == nil {
+				n += minLen(s)
+			}
+		}
+	}
+	return n
+}
+
+// Search scans needle for all non-overlapping matches of p. For each match,
+// Search calls f with the starting and ending offsets of the match, along with
+// the bindings captured from the match. If f reports an error, the search
+// ends.  If the error is ErrStopSearch, Search returns nil. Otherwise Search
+// returns the error from f. If p was parsed with the NoEmpty option, matches
+// of length zero are skipped.
+func (p *P) Search(needle string, f func(start, end int, binds Binds) error) error {
+	re, err := p.compileRegexp()
+	if err != nil {
+		return err
+	}
+	return p.search(re, needle, f)
+}
+
+// All returns an iterator over the non-overlapping matches of p in needle,
+// as reported by Search, yielding the starting offset and the bindings of
+// each match. If the regexp for p cannot be compiled, the iterator yields no
+// matches; use Search to observe the error.
+func (p *P) All(needle string) iter.Seq2[int, Binds] {
+	return func(yield func(int, Binds) bool) {
+		p.Search(needle, func(start, _ int, binds Binds) error {
+			if !yield(start, binds) {
+				return ErrStopSearch
+			}
+			return nil
+		})
+	}
+}
+
+// Split slices needle into the substrings separated by the non-overlapping
+// matches of p, as reported by Search, and returns the substrings between
+// those matches. It behaves like the Split method of regexp.Regexp with n < 0:
+// a match at the start or end of needle, or two adjacent matches, produce an
+// empty string in the result, and an empty needle yields a single empty
+// string. If the regexp for p cannot be compiled, Split returns nil.
+func (p *P) Split(needle string) []string {
+	if needle == "" {
+		return []string{""}
+	}
+	var out []string
+	beg, end := 0, 0
+	if err := p.Search(needle, func(start, stop int, _ Binds) error {
+		end = start
+		if stop != 0 {
+			out = append(out, needle[beg:end])
+		}
+		beg = stop
+		return nil
+	}); err != nil {
+		return nil
+	}
+	if end != len(needle) {
+		out = append(out, needle[beg:])
+	}
+	return out
+}
+
+// Coverage returns the spans of needle covered by the non-overlapping matches
+// of p, as reported by Search, in ascending order. Each span is a pair of
+// starting and ending offsets. Matches that abut one another are merged into
+// a single span, and empty matches are omitted. If the regexp for p cannot be
+// compiled, Coverage returns nil.
+func (p *P) Coverage(needle string) [][2]int {
+	var spans [][2]int
+	if err := p.Search(needle, func(start, end int, _ Binds) error {
+		if start == end {
+			return nil
+		} else if n := len(spans); n > 0 && spans[n-1][1] >= start {
+			spans[n-1][1] = max(spans[n-1][1], end)
+		} else {
+			spans = append(spans, [2]int{start, end})
+		}
+		return nil
+	}); err != nil {
+		return nil
+	}
+	return spans
+}
+
+// FindLast reports the starting and ending offsets and the bindings of the
+// last of the non-overlapping matches of p in needle, as reported by Search.
+// If there are no matches, FindLast reports ErrNoMatch.
+func (p *P) FindLast(needle string) (start, end int, binds Binds, err error) {
+	re, err := p.compileRegexp()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	ms := p.findAll(re, needle)
+	for p.noEmpty && len(ms) != 0 && ms[len(ms)-1][0] == ms[len(ms)-1][1] {
+		ms = ms[:len(ms)-1]
+	}
+	if len(ms) == 0 {
+		return 0, 0, nil, ErrNoMatch
+	}
+	m := ms[len(ms)-1]
+	return m[0], m[1], bindMatches(p, m, needle), nil
+}
+
+// Count reports the number of non-overlapping matches of p in needle, as
+// reported by Search. It is cheaper than Search, since it does not extract
+// the bindings of each match. Submatches are located only when p has
+// negative words or aliases, which must be checked for each match.
+func (p *P) Count(needle string) (int, error) {
+	re, err := p.compileRegexp()
+	if err != nil {
+		return 0, err
+	}
+	var ms [][]int
+	if len(p.negs) != 0 || p.hasAlias() {
+		ms = p.findAll(re, needle)
+	} else {
+		text, _ := p.foldNeedle(needle)
+		ms = re.FindAllStringIndex(text, -1)
+	}
+	var n int
+	for _, m := range ms {
+		if !p.noEmpty || m[0] != m[1] {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ContainsAll reports whether, for each distinct pattern word of p, needle
+// contains some substring matched by the expression bound to that word. The
+// literal text of the template and the order of the words are ignored, and
+// the substrings for different words may overlap, so this is only an
+// approximation of a match. It is useful as a cheap filter before calling
+// Match. Like Match, ContainsAll honors the UnicodeFold and DotAll options.
+// ContainsAll reports false if any expression cannot be compiled.
+func (p *P) ContainsAll(needle string) bool {
+	words, err := p.compileWords()
+	if err != nil {
+		return false
+	}
+	text, _ := p.foldNeedle(needle)
+	for _, re := range words {
+		if !re.MatchString(text) {
+			return false
+		}
+	}
+	return true
+}
+
+// compileWords compiles the expression bound to each distinct pattern word of
+// p, for use by ContainsAll.
+func (p *P) compileWords() ([]*regexp.Regexp, error) {
+	if p.words == nil {
+		words := []*regexp.Regexp{} // non-nil, to record that p is compiled
+		seen := make(map[string]bool)
+		for i := 1; i < len(p.parts); i += 2 {
+			name := p.groupWord(p.parts[i])
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			re, err := regexp.Compile(p.flagPrefix() + p.rules[name])
+			if err != nil {
+				return nil, fmt.Errorf("invalid expression for %q: %v", name, err)
+			}
+			words = append(words, re)
+		}
+		p.words = words
+	}
+	return p.words, nil
+}
+
+// SearchLines behaves like Search, but reports only matches that begin at the
+// start of a line and end at the end of a line in needle. A line ends at a
+// newline or at the end of needle.
+func (p *P) SearchLines(needle string, f func(start, end int, binds Binds) error) error {
+	re, err := p.compileLines()
+	if err != nil {
+		return err
+	}
+	return p.search(re, needle, f)
+}
+
+// SearchContext behaves like Search, but for each match calls f with a window
+// of needle around the match, including up to before bytes preceding the
+// match and up to after bytes following it. The window is clamped to the
+// bounds of needle. Offsets are in bytes, so a window may split a multibyte
+// rune at either end.
+func (p *P) SearchContext(needle string, before, after int, f func(ctx string, binds Binds) error) error {
+	return p.Search(needle, func(start, end int, binds Binds) error {
+		lo, hi := max(0, start-before), min(len(needle), end+after)
+		return f(needle[lo:hi], binds)
+	})
+}
+
+// SearchContiguous behaves like Search, but requires that the matches of p
+// cover needle completely, with each match beginning where the previous one
+// ended. If needle contains text not covered by a match, SearchContiguous
+// reports an error giving the offset of the first such text. Matches before
+// that point are still reported to f.
+func (p *P) SearchContiguous(needle string, f func(start, end int, binds Binds) error) error {
+	re, err := p.compileRegexp()
+	if err != nil {
+		return err
+	}
+	cur, stopped := 0, false
+	err = p.search(re, needle, func(start, end int, binds Binds) error {
+		if start != cur {
+			return fmt.Errorf("unmatched text at offset %d", cur)
+		}
+		cur = end
+		err := f(start, end, binds)
+		stopped = err == ErrStopSearch
+		return err
+	})
+	if err == nil && !stopped && cur != len(needle) {
+		return fmt.Errorf("unmatched text at offset %d", cur)
+	}
+	return err
+}
+
+// search calls f for each non-overlapping match of re in needle, as described
+// by Search.
+func (p *P) search(re *regexp.Regexp, needle string, f func(start, end int, binds Binds) error) error {
+	for _, m := range p.findAll(re, needle) {
+		if p.noEmpty && m[0] == m[1] {
+			continue
+		}
+		if err := f(m[0], m[1], bindMatches(p, m, needle)); err != nil {
+			if err == ErrStopSearch {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrStopSearch is a special error value that can be returned by the callback
+// to Search to terminate search early without error.
+var ErrStopSearch = errors.New("stopped searching")
+
+// ErrNoMatch is reported by Match when the pattern does not match the needle.
+var ErrNoMatch = errors.New("string does not match pattern")
+
+// Apply applies a list of bindings to the pattern template to produce a new
+// string. It is an error if the bindings do not cover the pattern words in the
+// template, meaning binds has at least one binding for each pattern word
+// mentioned by the template.
+//
+// If a pattern word appears in the template more often than in binds, the
+// value of the last matching binding is repeated to fill the remaining spots.
+func (p *P) Apply(binds []Bind) (string, error) { return p.apply(binds, nil) }
+
+// CheckRoundTrip reports an error if applying binds to p and matching the
+// result against p does not recover the values that were applied. This can
+// happen if a value does not match the expression bound to its word, or if it
+// can be confused with the literal text or values around it.
+func (p *P) CheckRoundTrip(binds []Bind) error {
+	s, err := p.Apply(binds)
+	if err != nil {
+		return err
+	}
+	got, err := p.Match(s)
+	if err != nil {
+		return fmt.Errorf("matching %q: %w", s, err)
+	}
+
+	// Reconstruct the values used by Apply for each occurrence.
+	sub := make(map[string][]string)
+	for _, bind := range binds {
+		sub[bind.Name] = append(sub[bind.Name], bind.Expr)
+	}
+	var i int
+	for j := 1; j < len(p.parts); j += 2 {
+		name := p.parts[j]
+		want := sub[name][0]
+		if len(sub[name]) > 1 {
+			sub[name] = sub[name][1:]
+		}
+		if i >= len(got) || got[i].Name != name || got[i].Expr != want {
+			return fmt.Errorf("value %q for %q was not recovered from %q", want, name, s)
+		}
+		i++
+	}
+	return nil
+}
+
+// CanApply reports whether Apply would succeed for binds, meaning binds has
+// at least one binding for each pattern word of p.
+func (p *P) CanApply(binds []Bind) bool {
+	for i := 1; i < len(p.parts); i += 2 {
+		if !Binds(binds).Has(p.parts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequireBindings reports an error if some pattern word of p is not a key of
+// names with a true value. The error lists the missing words in order of their
+// first occurrence in the template. This is useful to check that an external
+// source, such as a configuration map, supplies every word of a template.
+func (p *P) RequireBindings(names map[string]bool) error {
+	var missing []string
+	for i := 1; i < len(p.parts); i += 2 {
+		if name := p.parts[i]; !names[name] && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("no bindings for %q", missing)
+	}
+	return nil
+}
+
+// ApplyRecursive behaves like Apply, but first expands the values in binds
+// that themselves contain pattern words, such as "${name}". Each such word is
+// replaced by the expansion of the first value bound to its name in binds, so
+// a value may refer to another that refers to a third, and so on, up to
+// maxDepth levels. It is an error if a value still contains pattern words
+// after maxDepth levels, as happens when values refer to each other in a
+// cycle, or if a value refers to a name that is not bound.
+//
+// A value that does not parse as a template is used as written. In every
+// other value, including one with no pattern words, "$$" denotes a literal "$".
+func (p *P) ApplyRecursive(binds []Bind, maxDepth int) (string, error) {
+	var expand func(name, value string, depth int) (string, error)
+	expand = func(name, value string, depth int) (string, error) {
+		q, err := Parse(value, nil, NameRunes(p.extra))
+		if err != nil {
+			return value, nil
+		} else if !q.IsStatic() && depth >= maxDepth {
+			return "", fmt.Errorf("unresolved pattern words in %q after %d levels", name, maxDepth)
+		}
+		return q.ApplyFunc(func(word string, _ int) (string, error) {
+			for _, b := range binds {
+				if b.Name == word {
+					return expand(word, b.Expr, depth+1)
+				}
+			}
+			return "", errors.New("no value")
+		})
+	}
+	vals := make(Binds, len(binds))
+	for i, b := range binds {
+		s, err := expand(b.Name, b.Expr, 0)
+		if err != nil {
+			return "", fmt.Errorf("expanding %q: %v", b.Name, err)
+		}
+		vals[i] = Bind{Name: b.Name, Expr: s}
+	}
+	return p.Apply(vals)
+}
+
+// ApplyConsumeAll behaves like Apply, but reports an error if binds contains
+// more values for a pattern word than the template has occurrences of it.
+// Bindings for names that do not occur in the template are ignored.
+func (p *P) ApplyConsumeAll(binds []Bind) (string, error) {
+	need := make(map[string]int)
+	for i := 1; i < len(p.parts); i += 2 {
+		need[p.parts[i]]++
+	}
+	for _, bind := range binds {
+		if n, ok := need[bind.Name]; !ok {
+			continue
+		} else if n == 0 {
+			return "", fmt.Errorf("unused binding for %q", bind.Name)
+		}
+		need[bind.Name]--
+	}
+	return p.apply(binds, nil)
+}
+
+// ApplyUsed behaves like Apply, but also returns the bindings from binds whose
+// values were substituted into the result, in the order they were first used.
+// A binding whose value is repeated to fill later occurrences of its word is
+// reported only once. Bindings not in the result, such as those for names that
+// are not pattern words of p or surplus values for a word, were not used.
+func (p *P) ApplyUsed(binds []Bind) (string, []Bind, error) {
+	var out strings.Builder
+	var used []Bind
+	seen := make(map[int]bool)
+	if err := p.applyTo(&out, binds, nil, func(i int) {
+		if !seen[i] {
+			seen[i] = true
+			used = append(used, binds[i])
+		}
+	}); err != nil {
+		return "", nil, err
+	}
+	return out.String(), used, nil
+}
+
+// ApplyEscape behaves like Apply, but passes each substituted value through
+// escape before it is interpolated into the template. The literal text of the
+// template is not escaped. ApplyEscape will panic if escape == nil.
+func (p *P) ApplyEscape(binds []Bind, escape func(string) string) (string, error) {
+	if escape == nil {
+		panic("pattern: nil escape function")
+	}
+	return p.apply(binds, escape)
+}
+
+// AppendApply behaves like Apply, but appends the result to dst and returns
+// the extended slice, in the style of strconv.AppendInt. If application
+// fails, AppendApply returns dst unmodified along with the error.
+func (p *P) AppendApply(dst []byte, binds []Bind) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if err := p.applyTo(buf, binds, nil, nil); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// apply implements Apply and ApplyEscape. If escape != nil, it is applied to
+// each substituted value.
+func (p *P) apply(binds []Bind, escape func(string) string) (string, error) {
+	var out strings.Builder
+	if err := p.applyTo(&out, binds, escape, nil); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// applyTo writes the result of applying binds to the template of p to out,
+// as described by apply. If used != nil, it is called with the index in binds
+// of each value written, including repeats.
+func (p *P) applyTo(out io.StringWriter, binds []Bind, escape func(string) string, used func(int)) error {
+	if p.alts != nil {
+		return errAny
+	}
+	sub := make(map[string][]int) // :: name → indexes in binds
+	for i, bind := range binds {
+		sub[bind.Name] = append(sub[bind.Name], i)
+	}
+	skip := false // inside a conditional block that is not applied
+	for seg := range p.segments() {
+		part := seg.text
+		switch {
+		case seg.kind == segOpen:
+			s := sub[part]
+			skip = len(s) == 0 || binds[s[0]].Expr == ""
+		case seg.kind == segClose:
+			skip = false
+		case skip || seg.kind == segNeg:
+			continue
+		case seg.kind == segLit:
+			out.WriteString(part)
+		case len(sub[part]) == 0:
+			return fmt.Errorf("missing binding for %q", part)
+		default:
+			s := sub[part]
+			if escape != nil {
+				out.WriteString(escape(binds[s[0]].Expr))
+			} else {
+				out.WriteString(binds[s[0]].Expr)
+			}
+			if used != nil {
+				used(s[0])
+			}
+			if len(s) > 1 {
+				sub[part] = s[1:]
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyFuncTrim behaves like ApplyFunc, but f also reports whether to keep
+// each occurrence. If f reports keep == false for an occurrence, its value is
+// discarded along with the literal text that immediately precedes it in the
+// template, from the end of the previous pattern word (or the start of the
+// template) up to the occurrence. Literal text following the last pattern
+// word is always kept. As with ApplyFunc, f is also called for the condition
+// of each conditional block, and the block is applied only if f reports a
+// nonempty value that is kept. ApplyFuncTrim will panic if f == nil.
+func (p *P) ApplyFuncTrim(f func(name string, n int) (value string, keep bool, err error)) (string, error) {
+	if p.alts != nil {
+		return "", errAny
+	}
+	index := make(map[string]int) // :: name → index
+	var out, lit strings.Builder
+	skip := false // inside a conditional block that is not applied
+	for seg := range p.segments() {
+		part := seg.text
+		switch {
+		case seg.kind == segClose:
+			skip = false
+		case skip || seg.kind == segNeg:
+			continue
+		case seg.kind == segLit:
+			lit.WriteString(part)
+		default:
+			n := index[part] + 1
+			s, keep, err := f(part, n)
+			if err != nil {
+				return "", fmt.Errorf("binding %q: %v", part, err)
+			}
+			if seg.kind == segOpen {
+				skip = !keep || s == ""
+				continue
+			}
+			index[part] = n
+			if keep {
+				out.WriteString(lit.String())
+				out.WriteString(s)
+			}
+			lit.Reset()
+		}
+	}
+	out.WriteString(lit.String())
+	return out.String(), nil
+}
+
+// ApplyValues interpolates vals into the template of p positionally, so that
+// vals[i] replaces the ith pattern word occurrence in the template, regardless
+// of its name. It is an error if there are fewer values than occurrences;
+// extra values are ignored. A conditional block is applied only if the value
+// for the first occurrence of its condition in the template is not empty; if
+// the condition does not occur as a pattern word, the block is omitted.
+func (p *P) ApplyValues(vals []string) (string, error) {
+	if p.alts != nil {
+		return "", errAny
+	}
+	first := make(map[string]int) // :: name → index of first occurrence
+	for i := len(p.parts) - 1; i > 0; i-- {
+		if i%2 == 1 {
+			first[p.parts[i]] = i / 2
+		}
+	}
+	var out strings.Builder
+	n := 0        // index of the next pattern word occurrence
+	skip := false // inside a conditional block that is not applied
+	for seg := range p.segments() {
+		switch seg.kind {
+		case segOpen:
+			k, ok := first[seg.text]
+			skip = !ok || k >= len(vals) || vals[k] == ""
+		case segClose:
+			skip = false
+		case segLit:
+			if !skip {
+				out.WriteString(seg.text)
+			}
+		case segWord:
+			if n >= len(vals) {
+				return "", fmt.Errorf("missing value %d for %q", n, seg.text)
+			} else if !skip {
+				out.WriteString(vals[n])
+			}
+			n++
+		}
+	}
+	return out.String(), nil
+}
+
+// TemplateFunc returns a function that applies bindings to p, suitable for
+// use in the FuncMap of a text/template or html/template. The arguments to the
+// function are alternating names and values, each pair giving one binding in
+// order; names must be strings, and values are formatted as by fmt.Sprint.
+// For example, given
+//
+//	template.FuncMap{"greet": pattern.MustParse(`Hello, ${name}`, nil).TemplateFunc()}
+//
+// the template action {{greet "name" .User}} renders the greeting for .User.
+func (p *P) TemplateFunc() func(args ...interface{}) (string, error) {
+	return func(args ...interface{}) (string, error) {
+		if len(args)%2 != 0 {
+			return "", errors.New("odd number of arguments")
+		}
+		binds := make(Binds, 0, len(args)/2)
+		for i := 0; i < len(args); i += 2 {
+			name, ok := args[i].(string)
+			if !ok {
+				return "", fmt.Errorf("argument %d is %T, not a string name", i, args[i])
+			}
+			binds = append(binds, Bind{Name: name, Expr: fmt.Sprint(args[i+1])})
+		}
+		return p.Apply(binds)
+	}
+}
+
+// A BindFunc synthesizes a value for the nth occurrence (indexed from 1) of a
+// pattern word with the given name.
+type BindFunc func(name string, n int) (string, error)
+
+// EnvBindFunc returns a BindFunc that binds each occurrence of a pattern word
+// to the value of the environment variable whose name is prefix followed by
+// the name of the word. The BindFunc reports an error if the variable is not
+// set; a variable that is set to the empty string binds an empty value.
+func EnvBindFunc(prefix string) BindFunc {
+	return func(name string, _ int) (string, error) {
+		if v, ok := os.LookupEnv(prefix + name); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("environment variable %s is not set", prefix+name)
+	}
+}
+
+// EnvOrEmptyBindFunc behaves like EnvBindFunc, but the BindFunc it returns
+// binds an empty value for an unset variable rather than reporting an error.
+func EnvOrEmptyBindFunc(prefix string) BindFunc {
+	return func(name string, _ int) (string, error) { return os.Getenv(prefix + name), nil }
+}
+
+// ApplyFunc applies bindings generated by f to the pattern template of p to
+// produce a new string.  If f reports an error, application fails.
+// ApplyFunc will panic if f == nil.
+func (p *P) ApplyFunc(f BindFunc) (string, error) {
+	if p.alts != nil {
+		return "", errAny
+	}
+	index := make(map[string]int) // :: name → index
+	var out strings.Builder
+	skip := false // inside a conditional block that is not applied
+	for seg := range p.segments() {
+		part := seg.text
+		switch {
+		case seg.kind == segClose:
+			skip = false
+		case skip || seg.kind == segNeg:
+			continue
+		case seg.kind == segLit:
+			out.WriteString(part)
+		default:
+			n := index[part] + 1
+			s, err := f(part, n)
+			if err != nil {
+				return "", fmt.Errorf("binding %q: %v", part, err)
+			}
+			if seg.kind == segOpen {
+				skip = s == ""
+				continue
+			}
+			index[part] = n
+			out.WriteString(s)
+		}
+	}
+	return out.String(), nil
+}
+
+// Fill applies values generated by next to the template of p to produce a
+// new string. Fill calls next once for each pattern word in the template, in
+// the order reported by Binds, with the name of the word and its index (from
+// 0) in that order. If next reports an error, filling fails.
+// Fill will panic if next == nil.
+func (p *P) Fill(next func(name string, index int) (string, error)) (string, error) {
+	binds := p.Binds()
+	for i, bind := range binds {
+		s, err := next(bind.Name, i)
+		if err != nil {
+			return "", fmt.Errorf("binding %q: %v", bind.Name, err)
+		}
+		binds[i].Expr = s
+	}
+	return p.Apply(binds)
+}
+
+// ApplyDefaults applies the values in defaults to the template of p, so that
+// every occurrence of each pattern word is replaced by defaults[name]. It is
+// an error if defaults has no value for some pattern word of p.
+func (p *P) ApplyDefaults(defaults map[string]string) (string, error) {
+	return p.ApplyFunc(func(name string, _ int) (string, error) {
+		if s, ok := defaults[name]; ok {
+			return s, nil
+		}
+		return "", errors.New("no default value")
+	})
+}
+
+// ApplyIndexed applies the values in base to the template of p. A pattern
+// word that occurs more than once in the template is replaced at its nth
+// occurrence (indexed from 1) by its base value followed by sep and n. For
+// example, if base["arg"] == "arg" and sep == "", the occurrences of ${arg}
+// become "arg1", "arg2", and so on. It is an error if base has no value for
+// some pattern word of p.
+func (p *P) ApplyIndexed(base map[string]string, sep string) (string, error) {
+	count := make(map[string]int)
+	for i := 1; i < len(p.parts); i += 2 {
+		count[p.parts[i]]++
+	}
+	return p.ApplyFunc(func(name string, n int) (string, error) {
+		s, ok := base[name]
+		if !ok {
+			return "", errors.New("missing base value")
+		} else if count[name] > 1 {
+			return s + sep + strconv.Itoa(n), nil
+		}
+		return s, nil
+	})
+}
+
+// Derive constructs a new compiled pattern, using the same pattern words as p
+// but with s as the template instead. It is an error if s refers to a pattern
+// word not known to p.
+func (p *P) Derive(s string) (*P, error) {
+	t, err := parse(s, p.extra, p.empty)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range append(t.pat, t.neg...) {
+		if _, ok := p.rules[name]; !ok {
+			return nil, fmt.Errorf("unknown pattern word %q", name)
+		}
+	}
+	out := p.clone()
+	out.template, out.parts, out.alts = s, t.parts(), nil
+	out.rules = make(map[string]string)
+	out.trim, out.lazy, out.marks = nil, t.lazy, t.marks
+	for _, name := range append(t.pat, t.neg...) {
+		for _, word := range []string{name, p.groupWord(name)} {
+			out.rules[word] = p.rules[word]
+			if p.trim[word] {
+				out.trim = addWord(out.trim, word)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Equivalent reports whether p and other are structurally equivalent, meaning
+// they have the same literal text and the same pattern words in the same
+// order, and each pattern word has the same expression and the same trimming
+// and laziness in both. Options given to Parse are also compared. Equivalent
+// patterns match and apply identically, but the converse need not hold, since
+// expressions are compared as strings.
+func (p *P) Equivalent(other *P) bool {
+	if len(p.parts) != len(other.parts) || len(p.alts) != len(other.alts) ||
+		p.loose != other.loose || p.noEmpty != other.noEmpty || p.same != other.same ||
+		p.sep != other.sep || p.fold != other.fold || p.inner != other.inner || p.extra != other.extra ||
+		p.space != other.space || p.dotAll != other.dotAll || p.empty != other.empty ||
+		!slices.Equal(p.alias, other.alias) || !slices.Equal(p.marks, other.marks) {
+		return false
+	}
+	for i, part := range p.parts {
+		if part != other.parts[i] {
+			return false
+		} else if i%2 == 1 && (p.rules[part] != other.rules[part] ||
+			p.trim[part] != other.trim[part] || p.lazy[part] != other.lazy[part]) {
+			return false
+		}
+	}
+	for i, alt := range p.alts {
+		if !alt.Equivalent(other.alts[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// clone returns a shallow copy of p without its cached regexps.
+func (p *P) clone() *P {
+	out := *p
+	out.re, out.lines, out.match, out.names, out.negs, out.words = nil, nil, nil, nil, nil, nil
+	return &out
+}
+
+// WordDiff reports the names of pattern words that occur in p but not in
+// other, and those that occur in other but not in p. Both results are sorted.
+// Negative words are not pattern words, so they are not compared.
+func (p *P) WordDiff(other *P) (onlyP, onlyOther []string) {
+	return wordsMissing(p.parts, other.parts), wordsMissing(other.parts, p.parts)
+}
+
+// CheckAmbiguous reports an error if p contains two pattern words that are
+// directly adjacent in the template, with no literal text between them. Such
+// a pattern may match a string in more than one way, and the split chosen by
+// the regexp engine is rarely the one intended.
+func (p *P) CheckAmbiguous() error {
+	for i := 1; i+2 < len(p.parts); i += 2 {
+		if p.parts[i+1] == "" {
+			return fmt.Errorf("pattern words %q and %q are adjacent", p.parts[i], p.parts[i+2])
+		}
+	}
+	return nil
+}
+
+// Lint returns a list of warnings about constructs in p that are legal but
+// often unintended: pattern words that are directly adjacent in the template
+// (see CheckAmbiguous), words with no expression or with an expression that
+// can match the empty string, negative words that would reject every match,
+// words given conflicting expressions by the bindings passed to Parse, and
+// cycles among the aliases given by Alias.
+// Each warning names the pattern word concerned. Lint returns nil if it has
+// no warnings.
+func (p *P) Lint() []string {
+	var out []string
+	for i := 1; i+2 < len(p.parts); i += 2 {
+		if p.parts[i+1] == "" {
+			out = append(out, fmt.Sprintf("word %q: directly followed by word %q", p.parts[i], p.parts[i+2]))
+		}
+	}
+	seen := make(map[string]bool)
+	for i := 1; i < len(p.parts); i += 2 {
+		name := p.parts[i]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if rule := p.rules[name]; rule == "" {
+			out = append(out, fmt.Sprintf("word %q: has no expression", name))
+		} else if re, err := regexp.Compile(`^(?:` + rule + `)$`); err == nil && re.MatchString("") {
+			out = append(out, fmt.Sprintf("word %q: expression %q can match the empty string", name, rule))
+		}
+	}
+	seenNeg := make(map[string]bool)
+	for _, m := range p.marks {
+		if m.kind != segNeg || seenNeg[m.name] {
+			continue
+		}
+		seenNeg[m.name] = true
+		if rule := p.rules[m.name]; rule == "" {
+			out = append(out, fmt.Sprintf("negative word %q: has no expression, so it rejects every match", m.name))
+		} else if re, err := regexp.Compile(`^(?:` + rule + `)`); err == nil && re.MatchString("") {
+			out = append(out, fmt.Sprintf("negative word %q: expression %q can match the empty string, so it rejects every match", m.name, rule))
+		}
+	}
+	for _, name := range p.dups {
+		out = append(out, fmt.Sprintf("word %q: bound to conflicting expressions", name))
+	}
+	for _, pair := range p.alias {
+		cur := pair[1]
+		for range p.alias {
+			if cur = p.groupWord(cur); cur == pair[1] {
+				out = append(out, fmt.Sprintf("word %q: alias cycle", pair[1]))
+				break
+			}
+		}
+	}
+	return out
+}
+
+// wordsMissing returns the sorted names of the pattern words in the template
+// parts a that do not occur in the template parts b.
+func wordsMissing(a, b []string) []string {
+	inB := make(map[string]bool)
+	for i := 1; i < len(b); i += 2 {
+		inB[b[i]] = true
+	}
+	var out []string
+	for i := 1; i < len(a); i += 2 {
+		if !inB[a[i]] && !slices.Contains(out, a[i]) {
+			out = append(out, a[i])
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// compileRegexp assembles and compiles a regexp that matches the complete
+// template string with the subexpressions for pattern words injected.
+func (p *P) compileRegexp() (*regexp.Regexp, error) {
+	if p.re == nil {
+		for _, alt := range p.alts {
+			if _, err := alt.compileRegexp(); err != nil { // for bindAlt
+				return nil, err
+			}
+		}
+		expr, err := p.regexpSource()
+		if err != nil {
+			return nil, err
+		}
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, r.NumSubexp()+1)
+		for i, name := range r.SubexpNames() {
+			names[i], _ = WordName(name)
+		}
+		negs, err := p.compileNegs(r, names)
+		if err != nil {
+			return nil, err
+		}
+		// Cache the results only once they are complete, so that a failure is
+		// reported again on the next call.
+		p.re, p.min, p.names, p.negs = r, p.MinLen(), names, negs
+	}
+	return p.re, nil
+}
+
+// negGroupPrefix is prepended to the encoded name of a negative word to form
+// the name of its group. It is not a valid encoding of any pattern word, as
+// described by ExportRegexp, so it cannot collide with the group of a word.
+const negGroupPrefix = "_neg_"
+
+// compileNegs returns the checks for the negative words of p, indexed by the
+// groups of re, and clears the names of those groups in names so that they are
+// not reported as bindings. The result is nil if p has no negative words. For
+// a pattern constructed by Any, the checks are those of its alternatives.
+func (p *P) compileNegs(re *regexp.Regexp, names []string) ([]*regexp.Regexp, error) {
+	var negs []*regexp.Regexp
+	set := func(i int, neg *regexp.Regexp) {
+		if negs == nil {
+			negs = make([]*regexp.Regexp, len(names))
+		}
+		negs[i] = neg
+		names[i] = ""
+	}
+	if p.alts != nil {
+		g := 1
+		for _, alt := range p.alts {
+			for j, neg := range alt.negs {
+				if neg != nil {
+					set(g+j, neg)
+				}
+			}
+			g += alt.re.NumSubexp() + 1
+		}
+		return negs, nil
+	}
+	for i, group := range re.SubexpNames() {
+		rest, ok := strings.CutPrefix(group, negGroupPrefix)
+		if !ok {
+			continue
+		}
+		name, _ := WordName(rest)
+		neg, err := regexp.Compile(p.flagPrefix() + `\A(?:` + p.rules[name] + `)`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for %q: %v", name, err)
+		}
+		set(i, neg)
+	}
+	return negs, nil
+}
+
+// compileMatch assembles and compiles a regexp for use by Match. It is the
+// same as compileRegexp, but anchored to the ends of the needle required by p.
+func (p *P) compileMatch() (*regexp.Regexp, error) {
+	if p.match == nil {
+		re, err := p.compileRegexp() // for validation and p.min
+		if err != nil {
+			return nil, err
+		}
+		expr := `(?:` + re.String() + `)`
+		if p.loose&anchorStart == 0 {
+			expr = `\A` + expr
+		}
+		if p.loose&anchorEnd == 0 {
+			expr += `\z`
+		}
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		p.match = r
+	}
+	return p.match, nil
+}
+
+// compileLines assembles and compiles a regexp that matches the complete
+// template string, as compileRegexp, anchored to line boundaries.
+func (p *P) compileLines() (*regexp.Regexp, error) {
+	if p.lines == nil {
+		re, err := p.compileRegexp() // for validation and p.names
+		if err != nil {
+			return nil, err
+		}
+		r, err := regexp.Compile(`(?m)^(?:` + re.String() + `)$`)
+		if err != nil {
+			return nil, err
+		}
+		p.lines = r
+	}
+	return p.lines, nil
 }
 
-// Match reports whether needle matches p, and if so returns a list of bindings
-// for the pattern words occurring in s.  Because the same pattern word may
-// occur multiple times in the pattern, the order of bindings is significant.
-//
-// If matching fails, Match returns nil, ErrNoMatch.
-// If matching succeeds but no bindings are found, Match returns nil, nil.
-func (p *P) Match(needle string) (Binds, error) {
-	re, err := p.compileRegexp()
-	if err != nil {
-		return nil, err
+// syntaxFlags returns the flags with which the expressions bound to the
+// pattern words of p are parsed.
+func (p *P) syntaxFlags() syntax.Flags {
+	if p.dotAll {
+		return syntax.Perl | syntax.DotNL
 	}
-	m := re.FindStringSubmatchIndex(needle)
-	if m == nil || m[0] != 0 || m[1] != len(needle) {
-		return nil, ErrNoMatch
+	return syntax.Perl
+}
+
+// flagPrefix returns the flags to prepend to a regexp compiled directly from
+// an expression bound to a pattern word of p.
+func (p *P) flagPrefix() string {
+	if p.dotAll {
+		return `(?s)`
 	}
-	return bindMatches(re, m, needle), nil
+	return ""
 }
 
-// Search scans needle for all non-overlapping matches of p. For each match,
-// Search calls f with the starting and ending offsets of the match, along with
-// the bindings captured from the match. If f reports an error, the search
-// ends.  If the error is ErrStopSearch, Search returns nil. Otherwise Search
-// returns the error from f.
-func (p *P) Search(needle string, f func(start, end int, binds Binds) error) error {
-	re, err := p.compileRegexp()
-	if err != nil {
-		return err
+// regexpSource assembles the source of a regexp that matches the complete
+// template string with the subexpressions for pattern words injected.
+func (p *P) regexpSource() (string, error) {
+	if p.alts != nil {
+		return p.altSource()
 	}
-	for _, m := range re.FindAllStringSubmatchIndex(needle, -1) {
-		if err := f(m[0], m[1], bindMatches(re, m, needle)); err != nil {
-			if err == ErrStopSearch {
-				return nil
-			}
-			return err
+	var expr strings.Builder
+	for seg := range p.segments() {
+		switch seg.kind {
+		case segLit:
+			expr.WriteString(p.quoteLiteral(seg.text))
+			continue
+		case segOpen:
+			expr.WriteString(`(?:`)
+			continue
+		case segClose:
+			expr.WriteString(`)?`)
+			continue
+		case segNeg:
+			fmt.Fprintf(&expr, `(?P<%s>)`, negGroupPrefix+groupName(seg.text))
+			continue
 		}
+		part := p.groupWord(seg.text)
+		rule, ok := p.rules[part]
+		if !ok {
+			return "", fmt.Errorf("no binding for %q", part)
+		}
+		s, err := syntax.Parse(rule, p.syntaxFlags())
+		if err != nil {
+			return "", fmt.Errorf("invalid expression for %q: %v", part, err)
+		}
+		if p.inner {
+			s = renameCaptures(s, part)
+		} else {
+			s = stripCaptures(s)
+		}
+		if p.lazy[part] {
+			s = makeLazy(s)
+		}
+		fmt.Fprintf(&expr, `(?P<%s>%s)`, groupName(part), s.String())
 	}
-	return nil
+	return expr.String(), nil
 }
 
-// ErrStopSearch is a special error value that can be returned by the callback
-// to Search to terminate search early without error.
-var ErrStopSearch = errors.New("stopped searching")
+// quoteLiteral returns a regexp that matches the literal text s. If p has a
+// separator class, each run of separator characters in s matches any run of
+// those characters. If p uses case folding, s is folded.
+func (p *P) quoteLiteral(s string) string {
+	if p.fold {
+		s = fold(s)
+	}
+	if p.sep == "" {
+		return regexp.QuoteMeta(s)
+	}
+	var class strings.Builder
+	class.WriteString("[")
+	for _, c := range p.sep {
+		if c < utf8.RuneSelf && (unicode.IsPunct(c) || unicode.IsSymbol(c)) {
+			class.WriteByte('\\')
+		}
+		class.WriteRune(c)
+	}
+	class.WriteString("]+")
 
-// ErrNoMatch is reported by Match when the pattern does not match the needle.
-var ErrNoMatch = errors.New("string does not match pattern")
+	var buf strings.Builder
+	inSep := false
+	for i, c := range s {
+		isSep := strings.ContainsRune(p.sep, c)
+		if isSep && !inSep {
+			buf.WriteString(class.String())
+		} else if !isSep {
+			buf.WriteString(regexp.QuoteMeta(s[i : i+utf8.RuneLen(c)]))
+		}
+		inSep = isSep
+	}
+	return buf.String()
+}
 
-// Apply applies a list of bindings to the pattern template to produce a new
-// string. It is an error if the bindings do not cover the pattern words in the
-// template, meaning binds has at least one binding for each pattern word
-// mentioned by the template.
-//
-// If a pattern word appears in the template more often than in binds, the
-// value of the last matching binding is repeated to fill the remaining spots.
-func (p *P) Apply(binds []Bind) (string, error) {
-	sub := make(map[string][]string)
-	for _, bind := range binds {
-		sub[bind.Name] = append(sub[bind.Name], bind.Expr)
+// minLiteralLen returns the length in bytes of the shortest string matched by
+// the regexp for the literal text s, as constructed by quoteLiteral.
+func (p *P) minLiteralLen(s string) int {
+	if p.fold {
+		s = fold(s)
 	}
-	var out strings.Builder
-	for i, part := range p.parts {
-		if i%2 == 0 {
-			out.WriteString(part)
-		} else if s := sub[part]; len(s) == 0 {
-			return "", fmt.Errorf("missing binding for %q", part)
-		} else {
-			out.WriteString(s[0])
-			if len(s) > 1 {
-				sub[part] = s[1:]
-			}
+	if p.sep == "" {
+		return len(s)
+	}
+	var n int
+	inSep := false
+	for _, c := range s {
+		isSep := strings.ContainsRune(p.sep, c)
+		if !isSep {
+			n += utf8.RuneLen(c)
+		} else if !inSep {
+			n++
 		}
+		inSep = isSep
 	}
-	return out.String(), nil
+	return n
 }
 
-// A BindFunc synthesizes a value for the nth occurrence (indexed from 1) of a
-// pattern word with the given name.
-type BindFunc func(name string, n int) (string, error)
-
-// ApplyFunc applies bindings generated by f to the pattern template of p to
-// produce a new string.  If f reports an error, application fails.
-// ApplyFunc will panic if f == nil.
-func (p *P) ApplyFunc(f BindFunc) (string, error) {
-	index := make(map[string]int) // :: name → index
-	var out strings.Builder
-	for i, part := range p.parts {
-		if i%2 == 0 {
-			out.WriteString(part)
-			continue
-		}
-		n := index[part] + 1
-		index[part] = n
-		s, err := f(part, n)
-		if err != nil {
-			return "", fmt.Errorf("binding %q: %v", part, err)
-		}
-		out.WriteString(s)
+// ExportRegexp returns the source of a regular expression equivalent to p,
+// for use with other regexp engines. Each pattern word occurrence becomes a
+// named capture group, in the (?P<name>...) syntax, containing the bound
+// expression with its own capture groups made non-capturing. If anchored is
+// true, the expression is wrapped in \A and \z so that it matches only a
+// complete string; otherwise it matches anywhere, as Search does.
+//
+// Since regexp group names are limited to letters, digits, and underscores,
+// the other characters permitted in pattern words are encoded in group names.
+// An underscore is doubled, and any other character is replaced by an
+// underscore followed by its code as two hexadecimal digits; for example, the
+// pattern word "a:b_c" becomes the group name "a_3ab__c". Use WordName to
+// recover the original pattern word from a group name. A negative word
+// becomes an empty group named "_neg_" followed by the encoding of its name,
+// for which WordName reports false; the check it makes is not exported.
+func (p *P) ExportRegexp(anchored bool) (string, error) {
+	expr, err := p.regexpSource()
+	if err != nil {
+		return "", err
+	} else if anchored {
+		expr = `\A(?:` + expr + `)\z`
 	}
-	return out.String(), nil
+	return expr, nil
 }
 
-// Derive constructs a new compiled pattern, using the same pattern words as p
-// but with s as the template instead. It is an error if s refers to a pattern
-// word not known to p.
-func (p *P) Derive(s string) (*P, error) {
-	lit, pat, err := parse(s)
+// PrefixRegexp returns a regexp that matches exactly those strings that are a
+// prefix of some string matched in full by p. This is useful when the input
+// arrives incrementally: if the text received so far does not match the
+// result, no further input can make it match p.
+//
+// The expression is derived from the one that would be used by Match, so the
+// size of its source grows with the product of the size and the nesting depth
+// of the expressions bound to the pattern words. Matching remains linear in
+// the length of the input.
+//
+// A pattern word followed by more of the template has two groups with its
+// name: the first captures its text when the word is complete, and the second
+// captures the portion seen so far when the word is cut off by the end of the
+// input. At most one of them participates in a match, and SubexpIndex reports
+// the first; to find a cut-off word, check every group with its name. The
+// last word of the template has a single group. A word not yet reached does
+// not participate.
+// If p was parsed with UnicodeFold, the result must be matched against folded
+// text, since it cannot fold the needle itself.
+func (p *P) PrefixRegexp() (*regexp.Regexp, error) {
+	expr, err := p.regexpSource()
 	if err != nil {
 		return nil, err
 	}
-	for _, name := range pat {
-		if _, ok := p.rules[name]; !ok {
-			return nil, fmt.Errorf("unknown pattern word %q", name)
-		}
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
 	}
-	out := &P{template: s, rules: make(map[string]string)}
-	for i, part := range lit {
-		out.parts = append(out.parts, part)
-		if i < len(pat) {
-			out.parts = append(out.parts, pat[i])
-			out.rules[pat[i]] = p.rules[pat[i]]
+	return regexp.Compile(`\A(?:` + prefixOf(re).String() + `)\z`)
+}
+
+// groupName encodes a pattern word as a regexp capture group name, as
+// described by ExportRegexp.
+func groupName(word string) string {
+	var buf strings.Builder
+	for i := 0; i < len(word); i++ {
+		switch c := word[i]; {
+		case c == '_':
+			buf.WriteString("__")
+		case c >= '0' && c <= '9', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, "_%02x", c)
 		}
 	}
-	return out, nil
+	return buf.String()
 }
 
-// compileRegexp assembles and compiles a regexp that matches the complete
-// template string with the subexpressions for pattern words injected.
-func (p *P) compileRegexp() (*regexp.Regexp, error) {
-	if p.re == nil {
-		var expr strings.Builder
-		for i, part := range p.parts {
-			if i%2 == 0 {
-				expr.WriteString(regexp.QuoteMeta(part))
-				continue
-			}
-			rule, ok := p.rules[part]
-			if !ok {
-				return nil, fmt.Errorf("no binding for %q", part)
-			}
-			s, err := syntax.Parse(rule, syntax.Perl)
+// WordName decodes a regexp capture group name produced by ExportRegexp and
+// reports the corresponding pattern word. It reports false if group is not a
+// valid encoding of a pattern word.
+func WordName(group string) (string, bool) {
+	var buf strings.Builder
+	for i := 0; i < len(group); i++ {
+		if c := group[i]; c != '_' {
+			buf.WriteByte(c)
+		} else if i+1 < len(group) && group[i+1] == '_' {
+			buf.WriteByte('_')
+			i++
+		} else if i+2 < len(group) {
+			v, err := strconv.ParseUint(group[i+1:i+3], 16, 8)
 			if err != nil {
-				return nil, fmt.Errorf("invalid expression for %q: %v", part, err)
+				return group, false
 			}
-			fmt.Fprintf(&expr, `(?P<%s>%s)`, part, stripCaptures(s).String())
+			buf.WriteByte(byte(v))
+			i += 2
+		} else {
+			return group, false
 		}
-		r, err := regexp.Compile(expr.String())
-		if err != nil {
-			return nil, err
+	}
+	return buf.String(), true
+}
+
+// minLen returns a lower bound on the length in bytes of a string matched by
+// re. Character classes and case-folded literals are assumed to match a
+// single byte, since their shortest encoding is not tracked.
+func minLen(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return len(re.Rune)
+		}
+		return len(string(re.Rune))
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return minLen(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min * minLen(re.Sub[0])
+	case syntax.OpConcat:
+		var n int
+		for _, sub := range re.Sub {
+			n += minLen(sub)
+		}
+		return n
+	case syntax.OpAlternate:
+		n := -1
+		for _, sub := range re.Sub {
+			if m := minLen(sub); n < 0 || m < n {
+				n = m
+			}
 		}
-		p.re = r
+		return max(n, 0)
 	}
-	return p.re, nil
+	return 0 // empty, anchors, star, quest, no-match
 }
 
 // stripCaptures replaces capturing groups with non-capturing groups in re and
@@ -243,12 +1795,120 @@ func stripCaptures(re *syntax.Regexp) *syntax.Regexp {
 	return re
 }
 
+// renameCaptures behaves like stripCaptures, but keeps the named capturing
+// groups of re, renaming each to the group for the pattern word word.name.
+func renameCaptures(re *syntax.Regexp, word string) *syntax.Regexp {
+	if re.Op == syntax.OpCapture {
+		if re.Name == "" {
+			return renameCaptures(re.Sub[0], word)
+		}
+		re.Name = groupName(word + "." + re.Name)
+	}
+	for i, sub := range re.Sub {
+		re.Sub[i] = renameCaptures(sub, word)
+	}
+	return re
+}
+
+// makeLazy marks all the repetition operators in re and its recursive
+// subexpressions as non-greedy.
+func makeLazy(re *syntax.Regexp) *syntax.Regexp {
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest, syntax.OpRepeat:
+		re.Flags |= syntax.NonGreedy
+	}
+	for _, sub := range re.Sub {
+		makeLazy(sub)
+	}
+	return re
+}
+
+// prefixOf returns a regexp that matches every prefix of a string matched by
+// re. The subexpressions of re may be shared with the result. For a
+// concatenation, the alternative in which an element is complete precedes
+// the one in which it is cut off, so a capture group that occurs in both
+// has its complete form first, and that form is preferred when both apply.
+func prefixOf(re *syntax.Regexp) *syntax.Regexp {
+	node := func(op syntax.Op, subs ...*syntax.Regexp) *syntax.Regexp {
+		return &syntax.Regexp{Op: op, Sub: subs}
+	}
+	switch re.Op {
+	case syntax.OpLiteral:
+		var out *syntax.Regexp
+		for i := len(re.Rune) - 1; i >= 0; i-- {
+			lit := &syntax.Regexp{Op: syntax.OpLiteral, Flags: re.Flags, Rune: re.Rune[i : i+1]}
+			if out != nil {
+				lit = node(syntax.OpConcat, lit, out)
+			}
+			out = node(syntax.OpQuest, lit)
+		}
+		return out
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return node(syntax.OpQuest, re)
+	case syntax.OpCapture:
+		c := *re
+		c.Sub = []*syntax.Regexp{prefixOf(re.Sub[0])}
+		return &c
+	case syntax.OpQuest:
+		return prefixOf(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		if re.Op == syntax.OpRepeat && re.Max == 0 {
+			return node(syntax.OpEmptyMatch)
+		}
+		head := &syntax.Regexp{Op: syntax.OpStar, Flags: re.Flags, Sub: re.Sub}
+		if re.Op == syntax.OpRepeat && re.Max > 0 {
+			head = &syntax.Regexp{Op: syntax.OpRepeat, Flags: re.Flags, Min: 0, Max: re.Max - 1, Sub: re.Sub}
+		}
+		return node(syntax.OpConcat, head, prefixOf(re.Sub[0]))
+	case syntax.OpConcat:
+		if len(re.Sub) == 0 {
+			return re
+		}
+		out := prefixOf(re.Sub[len(re.Sub)-1])
+		for i := len(re.Sub) - 2; i >= 0; i-- {
+			out = node(syntax.OpAlternate, node(syntax.OpConcat, re.Sub[i], out), prefixOf(re.Sub[i]))
+		}
+		return out
+	case syntax.OpAlternate:
+		subs := make([]*syntax.Regexp, len(re.Sub))
+		for i, sub := range re.Sub {
+			subs[i] = prefixOf(sub)
+		}
+		return node(syntax.OpAlternate, subs...)
+	case syntax.OpBeginLine, syntax.OpBeginText, syntax.OpNoMatch:
+		return re // these do not depend on text that follows
+	}
+	return node(syntax.OpEmptyMatch) // empty, and assertions about text that follows
+}
+
+// Rest is an expression that matches all the remaining text of the needle,
+// including newlines, through to the end. Binding it to the last pattern word
+// of a template captures whatever follows the structured part of a match, as
+// in a log line with a free-text message. Since the match must extend to the
+// end of the needle, Search reports at most one match for such a pattern.
+const Rest = `(?s:.*)\z`
+
 // A Bind associates a pattern word name with a matching expression.
 type Bind struct {
 	Name string
 	Expr string
 }
 
+// Literal returns a copy of b whose expression matches the original text of
+// b.Expr literally. This is useful for re-binding a value captured by Match.
+func (b Bind) Literal() Bind { return Bind{Name: b.Name, Expr: regexp.QuoteMeta(b.Expr)} }
+
+// BindRE returns a Bind that associates name with the expression of re. Any
+// capturing groups in re are replaced with non-capturing groups, so that they
+// do not interfere with the groups used for pattern words.
+func BindRE(name string, re *regexp.Regexp) Bind {
+	src := re.String()
+	if sre, err := syntax.Parse(src, syntax.Perl); err == nil {
+		src = stripCaptures(sre).String()
+	}
+	return Bind{Name: name, Expr: src}
+}
+
 // Binds is an ordered collection of bindings.
 type Binds []Bind
 
@@ -285,39 +1945,298 @@ func (bs Binds) Has(key string) bool {
 }
 
 // Parse parses s into a pattern template, and binds the specified pattern
-// variables to the corresponding expressions.
-func Parse(s string, binds []Bind) (*P, error) {
-	lit, pat, err := parse(s)
+// variables to the corresponding expressions. The options, if any, modify
+// the behavior of the resulting pattern.
+func Parse(s string, binds []Bind, opts ...Option) (*P, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.strict {
+		if err := checkBinds(binds); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range o.extra {
+		if c >= utf8.RuneSelf || !unicode.IsPrint(c) || c == ' ' || strings.ContainsRune(`${}?\`, c) {
+			return nil, fmt.Errorf("invalid name character %q", c)
+		}
+	}
+	t, err := parse(s, o.extra, o.empty)
 	if err != nil {
 		return nil, err
 	}
-	var parts []string
 	rules := make(map[string]string)
-	for i, part := range lit {
-		parts = append(parts, part)
-		if i < len(pat) {
-			parts = append(parts, pat[i])
-			rules[pat[i]] = ""
+	for _, name := range append(t.pat, t.neg...) {
+		rules[name] = ""
+	}
+	for _, pair := range o.alias {
+		if _, ok := rules[pair[1]]; ok {
+			rules[pair[0]] = "" // the alias is matched by the target's rule
 		}
 	}
-	p := &P{template: s, parts: parts, rules: mergeBinds(rules, binds)}
+	p := &P{
+		template: s,
+		parts:    t.parts(),
+		rules:    mergeBinds(rules, binds),
+		lazy:     t.lazy,
+		marks:    t.marks,
+		dups:     conflictingBinds(binds),
+	}
+	if o.anchor != 0 {
+		p.loose = (anchorStart | anchorEnd) &^ o.anchor
+	}
+	p.noEmpty, p.same, p.sep, p.fold = o.noEmpty, o.same, o.sep, o.fold
+	p.alias, p.inner, p.extra, p.space = o.alias, o.inner, o.extra, o.space
+	p.dotAll, p.empty = o.dotAll, o.empty
 	return p, nil
 }
 
+// ParseMap parses s into a pattern template, as Parse, and binds each pattern
+// variable named in rules to the corresponding expression.
+func ParseMap(s string, rules map[string]string, opts ...Option) (*P, error) {
+	binds := make(Binds, 0, len(rules))
+	for name, expr := range rules {
+		binds = append(binds, Bind{Name: name, Expr: expr})
+	}
+	return Parse(s, binds, opts...)
+}
+
+// Printf constructs a pattern from a format string in the style of package
+// fmt, and binds its pattern words to the corresponding expressions. Each %s
+// verb in format is a pattern word, named by its position among the verbs:
+// "0", "1", and so on. A doubled percent sign (%%) is a literal percent sign.
+// Other verbs are not supported. All other characters, including "$", are
+// interpreted literally.
+func Printf(format string, binds Binds) (*P, error) {
+	var buf strings.Builder
+	var n int
+	for i := 0; i < len(format); i++ {
+		switch c := format[i]; {
+		case c == '$':
+			buf.WriteString("$$")
+		case c != '%':
+			buf.WriteByte(c)
+		case i+1 == len(format):
+			return nil, fmt.Errorf("at %d: incomplete verb", i)
+		case format[i+1] == '%':
+			buf.WriteByte('%')
+			i++
+		case format[i+1] == 's':
+			fmt.Fprintf(&buf, "${%d}", n)
+			n++
+			i++
+		default:
+			return nil, fmt.Errorf("at %d: unsupported verb %%%c", i, format[i+1])
+		}
+	}
+	return Parse(buf.String(), binds)
+}
+
 // Bind returns a copy of p with the specified bindings updated.  Existing
 // bindings of p not mentioned in binds are copied intact from p to the result.
 func (p *P) Bind(binds Binds) *P {
-	return &P{
-		template: p.template,
-		parts:    p.parts,
-		rules:    mergeBinds(p.rules, binds),
+	out := p.clone()
+	out.rules = mergeBinds(p.rules, binds)
+	return out
+}
+
+// Trim returns a copy of p in which the values captured for the specified
+// pattern words by Match and Search have leading and trailing whitespace
+// removed. Names that are not pattern words of p are ignored.
+//
+// Trimming discards information, so applying the trimmed bindings to p may not
+// reproduce the original string.
+func (p *P) Trim(names ...string) *P {
+	var trim map[string]bool
+	for name := range p.trim {
+		trim = addWord(trim, name)
+	}
+	for _, name := range names {
+		if _, ok := p.rules[name]; ok {
+			trim = addWord(trim, name)
+		}
+	}
+	out := p.clone()
+	out.trim = trim
+	return out
+}
+
+// addWord adds name to the set m, allocating m if necessary, and returns m.
+func addWord(m map[string]bool, name string) map[string]bool {
+	if m == nil {
+		m = make(map[string]bool)
+	}
+	m[name] = true
+	return m
+}
+
+// An Option modifies the behavior of a pattern constructed by Parse.
+type Option func(*options)
+
+type options struct {
+	anchor  int         // sides anchored by Match; 0 means both
+	strict  bool        // reject conflicting bindings
+	noEmpty bool        // skip empty matches in Search
+	same    bool        // require repeated words to match the same text
+	sep     string      // separator characters that match as a class
+	fold    bool        // match under Unicode case folding
+	alias   [][2]string // pairs of pattern words sharing a group
+	inner   bool        // report named groups within expressions
+	extra   string      // additional characters permitted in names
+	space   bool        // trim surrounding space from the needle in Match
+	dotAll  bool        // "." in expressions matches newline
+	empty   EmptyWord   // treatment of empty pattern words
+}
+
+// Sides of the needle to which a match may be anchored.
+const (
+	anchorStart = 1 << iota
+	anchorEnd
+)
+
+// AnchorStart is an option that requires a match to begin at the start of the
+// needle. If AnchorStart is given without AnchorEnd, Match reports a match of
+// any prefix of the needle.
+func AnchorStart() Option { return func(o *options) { o.anchor |= anchorStart } }
+
+// AnchorEnd is an option that requires a match to end at the end of the
+// needle. If AnchorEnd is given without AnchorStart, Match reports a match of
+// any suffix of the needle.
+func AnchorEnd() Option { return func(o *options) { o.anchor |= anchorEnd } }
+
+// StrictBinds is an option that makes Parse report an error if the same name
+// is bound to two different expressions. By default, the last binding for a
+// name is used. Repeated bindings of the same expression are permitted.
+func StrictBinds() Option { return func(o *options) { o.strict = true } }
+
+// NoEmpty is an option that makes Search and its variants skip matches of
+// length zero, so that each reported match consumes at least one byte of the
+// needle. Use MinLen to check whether a pattern can match the empty string.
+//
+// An empty match that is skipped is not retried at the same position with a
+// longer length, so a pattern that prefers an empty match at some position
+// reports nothing there. After a nonempty match, an empty match at the same
+// position is never reported, with or without this option.
+func NoEmpty() Option { return func(o *options) { o.noEmpty = true } }
+
+// SameValue is an option that makes Match require all the occurrences of
+// each pattern word to match the same text, as with a back-reference. If the
+// occurrences of a word capture different text, Match reports ErrNoMatch.
+// Since this is checked after the regexp has matched, Match does not try
+// other ways of matching the needle. Search and its variants are unaffected.
+func SameValue() Option { return func(o *options) { o.same = true } }
+
+// InnerGroups is an option that makes Match and Search report the text
+// captured by the named groups within the expression bound to a pattern word,
+// as additional bindings following the binding of the word. The name of each
+// such binding is the name of the word, a period, and the name of the group;
+// for example, a group "(?P<year>\d{4})" within the expression for "date" is
+// reported as "date.year". Unnamed groups are not reported. Without this
+// option, all the groups within an expression are made non-capturing.
+func InnerGroups() Option { return func(o *options) { o.inner = true } }
+
+// TrimSpace is an option that makes Match and MatchBytes remove leading and
+// trailing whitespace from the needle before matching it. The values captured
+// for pattern words are taken from the trimmed needle. Search and its variants
+// are unaffected, so the offsets they report always refer to the original
+// needle.
+func TrimSpace() Option { return func(o *options) { o.space = true } }
+
+// DotAll is an option that makes "." in the expressions bound to pattern
+// words match a newline, as with the (?s) flag. Without this option, a word
+// bound to ".*" cannot capture text spanning more than one line.
+func DotAll() Option { return func(o *options) { o.dotAll = true } }
+
+// NameRunes is an option that permits the characters of extra in the names of
+// pattern words, in addition to the letters, digits, and punctuation described
+// in the package documentation. For example, NameRunes(".@") permits words
+// such as ${user.name}. Only printable ASCII characters other than space, "$",
+// "{", "}", "?", and "\" may be added; Parse reports an error for any other. In
+// regexps, these characters are encoded in group names as described by
+// ExportRegexp.
+func NameRunes(extra string) Option { return func(o *options) { o.extra += extra } }
+
+// Alias is an option that makes the pattern words a and b share a single
+// captured value. Occurrences of b in the template are matched using the
+// expression bound to a, and all the occurrences of a and b must match the
+// same text, or Match and Search report no match. As with SameValue, this is
+// checked after the regexp has matched. The value is reported once, as a
+// binding of a followed by a binding of b, at the first occurrence of either.
+// Unlike SameValue, which relates the occurrences of one word, Alias relates
+// two differently-named words. Apply is unaffected.
+func Alias(a, b string) Option {
+	return func(o *options) { o.alias = append(o.alias, [2]string{a, b}) }
+}
+
+// An EmptyWord value selects how Parse treats an empty pattern word, "${}".
+type EmptyWord int
+
+const (
+	EmptyWordError   EmptyWord = iota // report ErrEmptyWord (the default)
+	EmptyWordLiteral                  // treat "${}" as literal text
+	EmptyWordSkip                     // remove "${}" from the template
+)
+
+// AllowEmptyWord is an option that makes Parse treat an empty pattern word,
+// "${}", as selected by mode, rather than reporting ErrEmptyWord. This is
+// useful for machine-generated templates. It does not affect conditional
+// blocks or negative words, whose names must not be empty. Derive uses the
+// same mode as the pattern from which it derives.
+func AllowEmptyWord(mode EmptyWord) Option { return func(o *options) { o.empty = mode } }
+
+// SeparatorClass is an option that makes each run of the characters of class
+// in the literal text of the template match any nonempty run of those
+// characters in the needle. For example, with SeparatorClass("-_ "), the
+// template "foo-bar" matches "foo-bar", "foo_bar", "foo bar", and "foo - bar".
+// The template itself, as reported by String, is unchanged.
+func SeparatorClass(class string) Option { return func(o *options) { o.sep = class } }
+
+// checkBinds reports an error if binds gives two different expressions for
+// the same name.
+func checkBinds(binds []Bind) error {
+	seen := make(map[string]string)
+	for _, bind := range binds {
+		if old, ok := seen[bind.Name]; ok && old != bind.Expr {
+			return fmt.Errorf("conflicting bindings for %q: %q and %q", bind.Name, old, bind.Expr)
+		}
+		seen[bind.Name] = bind.Expr
+	}
+	return nil
+}
+
+// conflictingBinds returns the names to which binds gives two different
+// expressions, in order of their first conflict.
+func conflictingBinds(binds []Bind) []string {
+	var out []string
+	seen := make(map[string]string)
+	for _, bind := range binds {
+		if old, ok := seen[bind.Name]; ok && old != bind.Expr && !slices.Contains(out, bind.Name) {
+			out = append(out, bind.Name)
+		}
+		seen[bind.Name] = bind.Expr
+	}
+	return out
+}
+
+// Literal constructs a pattern that matches exactly the text s, without
+// parsing s as a template, so that "$" and "{" in s have no special meaning.
+// The resulting pattern has no pattern words: Match succeeds only for a
+// needle equal to s, and Apply returns s. The template reported by the String
+// method has each "$" in s doubled, so that parsing it gives an equivalent
+// pattern.
+func Literal(s string) *P {
+	p := &P{template: strings.ReplaceAll(s, "$", "$$"), rules: make(map[string]string)}
+	if s != "" {
+		p.parts = []string{s}
 	}
+	return p
 }
 
 // MustParse parses s into a pattern template, as Parse, but panics if parsing
 // fails. This function exists to support static initialization.
-func MustParse(s string, binds []Bind) *P {
-	p, err := Parse(s, binds)
+func MustParse(s string, binds []Bind, opts ...Option) *P {
+	p, err := Parse(s, binds, opts...)
 	if err != nil {
 		panic("pattern: " + err.Error())
 	}
@@ -334,84 +2253,227 @@ func isWordRune(c rune) bool {
 	return false
 }
 
-// parse verifies the grammar of s, returning a slice of literals and a
-// corresponding slice of pattern labels.
-func parse(s string) (lit, pat []string, _ error) {
+// A parsed template, as reported by parse.
+type parsed struct {
+	lit, pat []string        // literals and the pattern words between them
+	spans    [][2]int        // offsets in the template of each pattern word
+	lazy     map[string]bool // pattern words marked with a trailing "?"
+	neg      []string        // names of negative words
+	marks    []mark          // conditional blocks and negative words, in order
+}
+
+// parts returns the literals and pattern words of t interleaved, in the form
+// stored in P.
+func (t *parsed) parts() []string {
+	var parts []string
+	for i, part := range t.lit {
+		parts = append(parts, part)
+		if i < len(t.pat) {
+			parts = append(parts, t.pat[i])
+		}
+	}
+	return parts
+}
+
+// parse verifies the grammar of s, returning its literals and pattern words
+// along with the other structure of the template. The characters of extra are
+// permitted in names in addition to those accepted by isWordRune.
+func parse(s, extra string, empty EmptyWord) (*parsed, error) {
 	const (
-		free   = iota // in literal text
-		dollar        // saw a $, looking for $ or {
-		word          // in a pattern word
+		free     = iota // in literal text
+		dollar          // saw a $, looking for $ or {
+		word            // in a pattern word
+		condName        // in the name of a conditional block
 	)
+	isName := func(c rune) bool { return isWordRune(c) || strings.ContainsRune(extra, c) }
 
-	start := 0           // start of most recent pattern word ($)
-	st := free           // lexer state
-	var buf bytes.Buffer // current token
+	var out parsed
+	start := 0            // start of most recent pattern word ($)
+	st := free            // lexer state
+	var text bytes.Buffer // current literal
+	var name bytes.Buffer // current pattern word
+	var marked bool       // current pattern word is marked lazy
+	var negated bool      // current pattern word is a negative word
+	var escaped bool      // previous character of a name was a backslash
+	var open string       // name of the current conditional block, if any
+	var openPos = -1      // start of the current conditional block ($), or -1
+	pos := func() [2]int { return [2]int{2 * len(out.lit), text.Len()} }
 	for i, c := range s {
 		switch st {
 		case free:
 			if c == '$' {
 				start = i
 				st = dollar
+			} else if c == '}' && openPos >= 0 {
+				out.marks = append(out.marks, mark{segClose, open, pos()})
+				openPos = -1
 			} else {
-				buf.WriteRune(c)
+				text.WriteRune(c)
 			}
 
 		case dollar:
-			if c == '$' {
-				buf.WriteRune(c)
-				st = free // escaped $
+			if c == '$' || (c == '}' && openPos >= 0) {
+				text.WriteRune(c)
+				st = free // escaped $ or }
 			} else if c == '{' {
-				lit = append(lit, buf.String())
-				buf.Reset()
 				st = word
 			} else {
-				return nil, nil, perrorf(i, "wanted $ or { but found '%c'", c)
+				return nil, perrorf(i, ErrIncompleteEscape, "wanted $ or { but found '%c'", c)
 			}
 
 		case word:
-			if c == '}' {
-				if buf.Len() == 0 {
-					return nil, nil, perrorf(start, "empty pattern word")
+			if escaped {
+				name.WriteRune(c)
+				escaped = false
+			} else if c == '\\' && !marked {
+				escaped = true
+			} else if c == '}' {
+				if name.Len() == 0 && !negated && empty != EmptyWordError {
+					if empty == EmptyWordLiteral {
+						text.WriteString("${}")
+					}
+					st = free
+					continue
+				} else if name.Len() == 0 {
+					return nil, perrorf(start, ErrEmptyWord, "empty pattern word")
+				}
+				if negated {
+					out.neg = append(out.neg, name.String())
+					out.marks = append(out.marks, mark{segNeg, name.String(), pos()})
+					name.Reset()
+					negated = false
+					st = free
+					continue
 				}
-				pat = append(pat, buf.String())
-				buf.Reset()
+				out.lit = append(out.lit, text.String())
+				out.pat = append(out.pat, name.String())
+				out.spans = append(out.spans, [2]int{start, i + 1})
+				if marked {
+					out.lazy = addWord(out.lazy, name.String())
+				}
+				text.Reset()
+				name.Reset()
+				marked = false
+				st = free
+			} else if c == '!' && name.Len() == 0 && !marked && !negated {
+				negated = true
+			} else if c == '?' && name.Len() == 0 && !marked && !negated {
+				if openPos >= 0 {
+					return nil, perrorf(start, ErrBadConditional, "nested conditional block")
+				}
+				st = condName
+			} else if c == '?' && !marked && !negated {
+				marked = true
+			} else if marked || !isName(c) {
+				return nil, perrorf(i, ErrInvalidNameChar, "invalid name letter '%c'", c)
+			} else {
+				name.WriteRune(c)
+			}
+
+		case condName:
+			if escaped {
+				name.WriteRune(c)
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if (c == ':' || c == '}') && name.Len() == 0 {
+				return nil, perrorf(start, ErrEmptyWord, "empty pattern word")
+			} else if c == ':' {
+				open, openPos = name.String(), start
+				out.marks = append(out.marks, mark{segOpen, open, pos()})
+				name.Reset()
 				st = free
-			} else if !isWordRune(c) {
-				return nil, nil, perrorf(i, "invalid name letter '%c'", c)
+			} else if c == '}' {
+				return nil, perrorf(i, ErrBadConditional, "wanted ':' after condition name")
+			} else if !isName(c) {
+				return nil, perrorf(i, ErrInvalidNameChar, "invalid name letter '%c'", c)
 			} else {
-				buf.WriteRune(c)
+				name.WriteRune(c)
 			}
 		}
 	}
-	if buf.Len() > 0 {
-		lit = append(lit, buf.String())
+	if text.Len() > 0 {
+		out.lit = append(out.lit, text.String())
 	}
 	switch st {
 	case dollar:
-		return nil, nil, perrorf(start, "incomplete $ escape")
-	case word:
-		return nil, nil, perrorf(start, "incomplete pattern word")
+		return nil, perrorf(start, ErrIncompleteEscape, "incomplete $ escape")
+	case word, condName:
+		return nil, perrorf(start, ErrIncompleteWord, "incomplete pattern word")
 	}
-	return lit, pat, nil
+	if openPos >= 0 {
+		return nil, perrorf(openPos, ErrBadConditional, "incomplete conditional block")
+	}
+	return &out, nil
 }
 
 // bindMatches extracts bindings from needle corresponding to the named capture
-// groups of re, given the submatch indices in m.
-func bindMatches(re *regexp.Regexp, m []int, needle string) Binds {
+// groups of the regexps of p, given the submatch indices in m.
+// Values for pattern words marked for trimming have surrounding whitespace
+// removed.
+func bindMatches[S string | []byte](p *P, m []int, needle S) Binds {
+	if p.alts != nil {
+		return bindAlt(p, m, needle)
+	}
 	var binds []Bind
-	for i, name := range re.SubexpNames() {
+	if n := len(p.parts) / 2; n > 0 {
+		binds = make([]Bind, 0, n)
+	}
+	for i, name := range p.names {
 		a, b := m[2*i], m[2*i+1]
 		if name == "" || a < 0 {
 			continue
 		}
-		binds = append(binds, Bind{
-			Name: name,
-			Expr: needle[a:b],
-		})
+		val := string(needle[a:b])
+		if p.trim[name] {
+			val = strings.TrimSpace(val)
+		}
+		binds = p.appendBind(binds, name, val)
+	}
+	if len(binds) == 0 {
+		return nil // e.g., all the words are in conditional blocks that did not match
+	}
+	return binds
+}
+
+// appendBind appends to binds a binding of name to val, followed by a binding
+// of val for each alias of name. If name is the target of an alias and is
+// already bound in binds, binds is returned unchanged, so that the value of
+// an alias group is reported only once.
+func (p *P) appendBind(binds Binds, name, val string) Binds {
+	if p.aliased(name) && binds.Has(name) {
+		return binds
+	}
+	binds = append(binds, Bind{Name: name, Expr: val})
+	for _, pair := range p.alias {
+		if pair[0] == name {
+			binds = append(binds, Bind{Name: pair[1], Expr: val})
+		}
 	}
 	return binds
 }
 
+// aliased reports whether name is the target of an alias of p.
+func (p *P) aliased(name string) bool {
+	for _, pair := range p.alias {
+		if pair[0] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// groupWord returns the name of the pattern word whose capture group is used
+// for occurrences of word, taking aliases into account.
+func (p *P) groupWord(word string) string {
+	for _, pair := range p.alias {
+		if pair[1] == word {
+			return pair[0]
+		}
+	}
+	return word
+}
+
 // mergeBinds returns a copy of old into which the given binds are merged.  The
 // result has the same keys as old, and the values for keys not mentioned in
 // binds are copied from old.
@@ -433,10 +2495,24 @@ func mergeBinds(old map[string]string, binds Binds) map[string]string {
 type ParseError struct {
 	Pos     int    // offset where error occurred
 	Message string // description of error
+	Err     error  // the category of error, e.g., ErrEmptyWord
 }
 
 func (p *ParseError) Error() string { return fmt.Sprintf("at %d: %s", p.Pos, p.Message) }
 
-func perrorf(pos int, msg string, args ...interface{}) *ParseError {
-	return &ParseError{pos, fmt.Sprintf(msg, args...)}
+// Unwrap returns the category of p, for use with errors.Is.
+func (p *ParseError) Unwrap() error { return p.Err }
+
+// Sentinel errors wrapped by a *ParseError to report the category of a
+// parsing failure.
+var (
+	ErrIncompleteEscape = errors.New("incomplete $ escape")
+	ErrIncompleteWord   = errors.New("incomplete pattern word")
+	ErrEmptyWord        = errors.New("empty pattern word")
+	ErrInvalidNameChar  = errors.New("invalid name letter")
+	ErrBadConditional   = errors.New("invalid conditional block")
+)
+
+func perrorf(pos int, err error, msg string, args ...interface{}) *ParseError {
+	return &ParseError{pos, fmt.Sprintf(msg, args...), err}
 }
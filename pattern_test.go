@@ -3,11 +3,14 @@ package pattern
 import (
 	"errors"
 	"fmt"
+	"html"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"text/template"
 )
 
 func TestParse(t *testing.T) {
@@ -45,6 +48,14 @@ func TestParse(t *testing.T) {
 		{"${a:b} ${c/d} ${_e_} ${--F} ${+gee} ${#25} ${h=18}",
 			[]string{"", "a:b", " ", "c/d", " ", "_e_", " ", "--F", " ", "+gee", " ", "#25", " ", "h=18"},
 			[]string{"a:b", "c/d", "_e_", "--F", "+gee", "#25", "h=18"}},
+
+		// Escaped characters in word names.
+		{`${a\ b}`, []string{"", "a b"}, []string{"a b"}},
+		{`${a\}b}c`, []string{"", "a}b", "c"}, []string{"a}b"}},
+		{`${\{x\}}`, []string{"", "{x}"}, []string{"{x}"}},
+		{`${\?x}${\!y}`, []string{"", "?x", "", "!y"}, []string{"?x", "!y"}},
+		{`${a\\b}`, []string{"", `a\b`}, []string{`a\b`}},
+		{`${a\?}`, []string{"", "a?"}, []string{"a?"}},
 	}
 	for _, test := range tests {
 		got, err := Parse(test.input, nil)
@@ -68,22 +79,161 @@ func TestParse(t *testing.T) {
 }
 
 func TestParseErrors(t *testing.T) {
-	tests := []string{
-		"$",     // incomplete escape
-		"a$",    // "
-		"$ ",    // "
-		"${",    // incomplete pattern word
-		"a${bc", // "
-		"${}",   // empty pattern word
-		"${ }",  // invalid name letter
-		"${a^}", // "
-	}
-	for _, test := range tests {
-		got, err := Parse(test, nil)
+	tests := []struct {
+		input string
+		want  error
+	}{
+		{"$", ErrIncompleteEscape},
+		{"a$", ErrIncompleteEscape},
+		{"$ ", ErrIncompleteEscape},
+		{"${", ErrIncompleteWord},
+		{"a${bc", ErrIncompleteWord},
+		{"${}", ErrEmptyWord},
+		{"${ }", ErrInvalidNameChar},
+		{"${a^}", ErrInvalidNameChar},
+		{"${a?b}", ErrInvalidNameChar},
+		{"${a??}", ErrInvalidNameChar},
+		{"${?}", ErrEmptyWord},
+		{"${?:x}", ErrEmptyWord},
+		{"${?a}", ErrBadConditional},
+		{"${?a:x", ErrBadConditional},
+		{"${?a:${?b:x}}", ErrBadConditional},
+		{"${?a b:x}", ErrInvalidNameChar},
+		{"${?a", ErrIncompleteWord},
+		{"x$}", ErrIncompleteEscape},
+		{`${a\`, ErrIncompleteWord},
+		{`${a\}`, ErrIncompleteWord},
+		{`${a?\b}`, ErrInvalidNameChar},
+		{`${\}`, ErrIncompleteWord},
+	}
+	for _, test := range tests {
+		got, err := Parse(test.input, nil)
 		if err == nil {
-			t.Errorf("Parse(%q): got %+v, wanted error", test, got)
+			t.Errorf("Parse(%q): got %+v, wanted error", test.input, got)
+		} else if !errors.Is(err, test.want) {
+			t.Errorf("Parse(%q): got error %v, want %v", test.input, err, test.want)
 		} else {
-			t.Logf("Parse(%q): correctly failed: %v", test, err)
+			t.Logf("Parse(%q): correctly failed: %v", test.input, err)
+		}
+	}
+}
+
+func TestStrictBinds(t *testing.T) {
+	tests := []struct {
+		binds Binds
+		ok    bool
+	}{
+		{nil, true},
+		{Binds{{"x", `\d+`}, {"y", `\w+`}}, true},
+		{Binds{{"x", `\d+`}, {"x", `\d+`}}, true},
+		{Binds{{"x", `\d+`}, {"y", `\w+`}, {"x", `\w+`}}, false},
+	}
+	for _, test := range tests {
+		if _, err := Parse(`${x} ${y}`, test.binds); err != nil {
+			t.Errorf("Parse %+v failed: %v", test.binds, err)
+		}
+		_, err := Parse(`${x} ${y}`, test.binds, StrictBinds())
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("Parse strict %+v: got error %v, want ok=%v", test.binds, err, test.ok)
+		}
+	}
+}
+
+func TestAllowEmptyWord(t *testing.T) {
+	const template = `a${}b${x}${}c`
+	tests := []struct {
+		mode  EmptyWord
+		parts []string
+	}{
+		{EmptyWordLiteral, []string{"a${}b", "x", "${}c"}},
+		{EmptyWordSkip, []string{"ab", "x", "c"}},
+	}
+	for _, test := range tests {
+		p, err := Parse(template, nil, AllowEmptyWord(test.mode))
+		if err != nil {
+			t.Errorf("Parse mode %d failed: %v", test.mode, err)
+			continue
+		}
+		if !reflect.DeepEqual(p.parts, test.parts) {
+			t.Errorf("Parse mode %d parts\ngot:  %+q\nwant: %+q", test.mode, p.parts, test.parts)
+		}
+		q, err := p.Derive(`${}[${x}]`)
+		if err != nil {
+			t.Errorf("Derive mode %d failed: %v", test.mode, err)
+		} else if q.empty != test.mode {
+			t.Errorf("Derive mode %d: got mode %d", test.mode, q.empty)
+		}
+	}
+
+	for _, opt := range []Option{nil, AllowEmptyWord(EmptyWordError)} {
+		var opts []Option
+		if opt != nil {
+			opts = append(opts, opt)
+		}
+		if _, err := Parse(template, nil, opts...); !errors.Is(err, ErrEmptyWord) {
+			t.Errorf("Parse with %d options: got %v, want %v", len(opts), err, ErrEmptyWord)
+		}
+	}
+
+	// Conditional blocks and negative words still require names.
+	for _, bad := range []string{"${?:x}", "${!}"} {
+		if _, err := Parse(bad, nil, AllowEmptyWord(EmptyWordSkip)); !errors.Is(err, ErrEmptyWord) {
+			t.Errorf("Parse(%q): got %v, want %v", bad, err, ErrEmptyWord)
+		}
+	}
+}
+
+func TestEscapedNames(t *testing.T) {
+	const template = `<${first\ name}|${a\}b}|${?x\:y:!}>`
+	p := MustParse(template, Binds{{"first name", `\w+`}, {"a}b", `\d+`}})
+	got, err := p.Match("<Alice|25|!>")
+	want := Binds{{"first name", "Alice"}, {"a}b", "25"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Match: got %+v, %v; want %+v", got, err, want)
+	}
+	if s, err := p.Apply(Binds{{"first name", "Bob"}, {"a}b", "3"}, {"x:y", "1"}}); err != nil || s != "<Bob|3|!>" {
+		t.Errorf("Apply: got %q, %v; want %q", s, err, "<Bob|3|!>")
+	}
+	if got, want := p.Format("${", "}"), template; got != want {
+		t.Errorf("Format: got %q, want %q", got, want)
+	}
+}
+
+func TestParseMap(t *testing.T) {
+	p, err := ParseMap(`${a}-${b}`, map[string]string{
+		"a": `\d+`, "b": `[xyz]`, "c": "unused",
+	})
+	if err != nil {
+		t.Fatalf("ParseMap failed: %v", err)
+	}
+	want := Binds{{"a", `\d+`}, {"b", `[xyz]`}}
+	if got := p.Binds(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong bindings:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestPrintf(t *testing.T) {
+	p, err := Printf(`%s costs $%s (100%%)`, Binds{{"0", `\w+`}, {"1", `[\d.]+`}})
+	if err != nil {
+		t.Fatalf("Printf failed: %v", err)
+	}
+	const input = "tea costs $2.50 (100%)"
+	m, err := p.Match(input)
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if want := (Binds{{"0", "tea"}, {"1", "2.50"}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Match: got %+v, want %+v", m, want)
+	}
+	if got, err := p.Apply(m); err != nil {
+		t.Errorf("Apply failed: %v", err)
+	} else if got != input {
+		t.Errorf("Apply: got %q, want %q", got, input)
+	}
+
+	for _, bad := range []string{"%", "a %d", "%s %v"} {
+		if p, err := Printf(bad, nil); err == nil {
+			t.Errorf("Printf(%q): got %v, wanted error", bad, p)
 		}
 	}
 }
@@ -119,6 +269,146 @@ func TestBind(t *testing.T) {
 	}
 }
 
+func TestExpr(t *testing.T) {
+	p := MustParse(`${a} ${b}`, Binds{{"a", `\d+`}})
+	tests := []struct {
+		name, want string
+		ok         bool
+	}{
+		{"a", `\d+`, true},
+		{"b", "", true},
+		{"c", "", false},
+	}
+	for _, test := range tests {
+		got, ok := p.Expr(test.name)
+		if got != test.want || ok != test.ok {
+			t.Errorf("Expr(%q): got %q, %v; want %q, %v", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestExprs(t *testing.T) {
+	p := MustParse(`${a} ${b} ${a}`, Binds{{"a", `\d+`}})
+	got := p.Exprs()
+	if want := map[string]string{"a": `\d+`, "b": ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Exprs: got %+v, want %+v", got, want)
+	}
+	got["a"] = "changed"
+	if expr, _ := p.Expr("a"); expr != `\d+` {
+		t.Errorf("Expr after modifying Exprs: got %q, want %q", expr, `\d+`)
+	}
+}
+
+func TestIsStatic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"", true},
+		{"foo", true},
+		{"$${foo}", true},
+		{"${foo}", false},
+		{"a ${b} c", false},
+	}
+	for _, test := range tests {
+		if got := MustParse(test.pattern, nil).IsStatic(); got != test.want {
+			t.Errorf("IsStatic %q: got %v, want %v", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		pattern, want string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"${a}", ""},
+		{"${a}${b}", ""},
+		{"a ${b} c", "a  c"},
+		{"$$${x}: ${y}.", "$: ."},
+	}
+	for _, test := range tests {
+		if got := MustParse(test.pattern, nil).Literal(); got != test.want {
+			t.Errorf("Literal %q: got %q, want %q", test.pattern, got, test.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		open, close string
+		want        string
+	}{
+		{"", "{{", "}}", ""},
+		{"a ${b} c", "{{", "}}", "a {{b}} c"},
+		{"$$${x}: ${y}.", "${", "}", "$$${x}: ${y}."},
+		{"100% ${n}", "%(", ")s", "100%% %(n)s"},
+		{"{x} ${x}", "{{", "}}", "{{x} {{x}}"},
+		{"${a}${b}", "<", ">", "<a><b>"},
+		{"$$ ${a}", "", "", "$ a"},
+		{"a${x?}${?y:[${y}]}", "${", "}", "a${x?}${?y:[${y}]}"},
+		{"a${x?}${?y:[${y}]}", "{{", "}}", "a{{x?}}{{?y:[{{y}}]}}"},
+		{"${!no}${w}", "<", ">", "<!no><w>"},
+		{"${?c:{$}}x}", "${", "}", "${?c:{$}}x}"},
+	}
+	for _, test := range tests {
+		p := MustParse(test.pattern, nil)
+		if got := p.Format(test.open, test.close); got != test.want {
+			t.Errorf("Format %q (%q, %q): got %q, want %q",
+				test.pattern, test.open, test.close, got, test.want)
+		}
+		if got := p.Format("${", "}"); !MustParse(got, nil).Equivalent(p) {
+			t.Errorf("Format %q: result %q is not equivalent", test.pattern, got)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	tests := []struct {
+		p    *P
+		want string
+	}{
+		{MustParse("", nil), ""},
+		{MustParse(`foo${bar}baz`, Binds{{"bar", `\d+`}}), `LIT "foo" WORD bar=\d+ LIT "baz"`},
+		{MustParse(`${a?} "${b}"`, Binds{{"a", `.*`}}), `WORD a?=.* LIT " \"" WORD b= LIT "\""`},
+		{MustParse(`x${?c:[${c}]}`, Binds{{"c", `\w`}}), `LIT "x" IF c LIT "[" WORD c=\w LIT "]" END`},
+	}
+	for _, test := range tests {
+		if got := test.p.Dump(); got != test.want {
+			t.Errorf("Dump %q: got %s, want %s", test.p, got, test.want)
+		}
+	}
+	alt, err := Any(MustParse("a", nil), MustParse("${b}", nil))
+	if err != nil {
+		t.Fatalf("Any failed: %v", err)
+	}
+	if got, want := alt.Dump(), `ANY(LIT "a" | WORD b=)`; got != want {
+		t.Errorf("Dump Any: got %s, want %s", got, want)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	p := MustParse(`cost: $$${n} (${unit})`, nil)
+	word := func(s string) string { return "\x1b[1m" + s + "\x1b[0m" }
+	lit := func(s string) string { return "<" + s + ">" }
+
+	tests := []struct {
+		word, lit func(string) string
+		want      string
+	}{
+		{nil, nil, `cost: $$${n} (${unit})`},
+		{word, nil, "cost: $$\x1b[1m${n}\x1b[0m (\x1b[1m${unit}\x1b[0m)"},
+		{nil, lit, "<cost: $$>${n}< (>${unit}<)>"},
+	}
+	for _, test := range tests {
+		if got := p.Highlight(test.word, test.lit); got != test.want {
+			t.Errorf("Highlight: got %q, want %q", got, test.want)
+		}
+	}
+}
+
 func TestMatch(t *testing.T) {
 	tests := []struct {
 		pattern string
@@ -171,106 +461,958 @@ func TestMatch(t *testing.T) {
 		if !reflect.DeepEqual(m, test.want) {
 			t.Errorf("Match %q:\ngot:  %+v\nwant: %+v", test.needle, m, test.want)
 		}
+
+		mb, err := p.MatchBytes([]byte(test.needle))
+		if err != nil {
+			t.Errorf("MatchBytes %q failed: %v", test.needle, err)
+		} else if !reflect.DeepEqual(mb, test.want) {
+			t.Errorf("MatchBytes %q:\ngot:  %+v\nwant: %+v", test.needle, mb, test.want)
+		}
+	}
+
+	p := MustParse("x${a}y", Binds{{"a", `\d+`}})
+	if m, err := p.MatchBytes([]byte("x12yz")); err != ErrNoMatch {
+		t.Errorf("MatchBytes: got %+v, %v; want %v", m, err, ErrNoMatch)
 	}
 }
 
-func TestMatchErrors(t *testing.T) {
-	t.Run("BadCompile", func(t *testing.T) {
-		p := MustParse(`arg${vowel}naut`, []Bind{{"vowel", "[bad"}})
-		m, err := p.Match("it got better")
-		if err == nil {
-			t.Errorf("Match: got %+v, wanted error", m)
-		} else {
-			t.Logf("Match: correctly failed: %v", err)
+func TestBindLiteral(t *testing.T) {
+	p := MustParse(`${fn}(${arg})`, Binds{{"fn", `\w+`}, {"arg", `[^()]*`}})
+	m, err := p.Match("f(a.b*[c])")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+
+	// Re-bind the captured argument as a literal and match against it.
+	q := MustParse(`call ${arg}`, Binds{m[1].Literal()})
+	if _, err := q.Match("call a.b*[c]"); err != nil {
+		t.Errorf("Match literal failed: %v", err)
+	}
+	if got, err := q.Match("call aXbbb[c]"); err != ErrNoMatch {
+		t.Errorf("Match literal: got %+v, %v; want %v", got, err, ErrNoMatch)
+	}
+}
+
+func TestBindRE(t *testing.T) {
+	b := BindRE("x", regexp.MustCompile(`(?i)(a+)(?P<rest>b*)`))
+	if b.Name != "x" {
+		t.Errorf("BindRE name: got %q, want x", b.Name)
+	}
+	if strings.Contains(b.Expr, "(a") || strings.Contains(b.Expr, "?P<") {
+		t.Errorf("BindRE expr: got %q, want captures stripped", b.Expr)
+	}
+
+	p := MustParse(`<${x}>`, Binds{b})
+	m, err := p.Match("<AaBb>")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got := m.First("x"); got != "AaBb" {
+		t.Errorf("Match x: got %q, want AaBb", got)
+	}
+}
+
+func TestTrimSpace(t *testing.T) {
+	binds := Binds{{"k", `\w+`}, {"v", `\w*`}}
+	p := MustParse(`${k}=${v}`, binds, TrimSpace())
+	for _, needle := range []string{"a=b", "  a=b", "a=b\n", "\t a=b \r\n"} {
+		got, err := p.Match(needle)
+		if err != nil {
+			t.Errorf("Match %q failed: %v", needle, err)
+		} else if want := (Binds{{"k", "a"}, {"v", "b"}}); !reflect.DeepEqual(got, want) {
+			t.Errorf("Match %q: got %+v, want %+v", needle, got, want)
 		}
-	})
-	t.Run("ErrNoMatch", func(t *testing.T) {
-		p := MustParse(`arg${vowel}naut`, []Bind{{"vowel", "(?i)[aeiou]"}})
-		for _, test := range []string{
-			"",           // no match
-			"argo",       // incomplete match
-			"naut",       // "
-			" argonaut ", // match does not consume the whole string
-		} {
-			m, err := p.Match(test)
-			if err == nil {
-				t.Errorf("Match %q: got %+v, wanted error", test, m)
-			} else {
-				t.Logf("Match %q: correctly failed: %v", test, err)
-			}
+		if _, err := p.MatchBytes([]byte(needle)); err != nil {
+			t.Errorf("MatchBytes %q failed: %v", needle, err)
 		}
+	}
+	if _, err := MustParse(`${k}=${v}`, binds).Match(" a=b"); err != ErrNoMatch {
+		t.Errorf("Match without TrimSpace: got %v, want %v", err, ErrNoMatch)
+	}
+
+	// Search is unaffected.
+	var starts []int
+	p.Search("  a=b", func(start, _ int, _ Binds) error {
+		starts = append(starts, start)
+		return nil
 	})
-	t.Run("NoBinding", func(t *testing.T) {
-		p := MustParse(`arg${o}naut`, nil)
-		m, err := p.Match("argonaut")
-		if err == nil {
-			t.Errorf("Match: got %+v, wanted error", m)
-		} else {
-			t.Logf("Match correctly failed: %v", err)
+	if want := []int{2}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("Search: got starts %v, want %v", starts, want)
+	}
+}
+
+func TestDotAll(t *testing.T) {
+	const needle = "<<BEGIN\nfirst line\nsecond line\nEND>>"
+	binds := Binds{{"body", `.*`}}
+	p := MustParse("<<BEGIN\n${body}\nEND>>", binds, DotAll())
+	got, err := p.Match(needle)
+	if want := "first line\nsecond line"; err != nil || got.First("body") != want {
+		t.Errorf("Match: got %+v, %v; want body=%q", got, err, want)
+	}
+	if _, err := MustParse(p.String(), binds).Match(needle); err != ErrNoMatch {
+		t.Errorf("Match without DotAll: got %v, want %v", err, ErrNoMatch)
+	}
+
+	// The option is preserved by derived patterns.
+	q, err := p.Derive("[${body}]")
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	if got, err := q.Bind(Binds{{"other", `\d+`}}).Match("[a\nb]"); err != nil || got.First("body") != "a\nb" {
+		t.Errorf("Derive+Bind Match: got %+v, %v; want body=%q", got, err, "a\nb")
+	}
+}
+
+func TestNameRunes(t *testing.T) {
+	const template = `${user.name}@${host}`
+	if _, err := Parse(template, nil); !errors.Is(err, ErrInvalidNameChar) {
+		t.Errorf("Parse without NameRunes: got %v, want %v", err, ErrInvalidNameChar)
+	}
+	p, err := Parse(template, Binds{{"user.name", `\w+`}, {"host", `[\w.]+`}}, NameRunes("."))
+	if err != nil {
+		t.Fatalf("Parse with NameRunes failed: %v", err)
+	}
+	got, err := p.Match("alice@example.com")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if want := (Binds{{"user.name", "alice"}, {"host", "example.com"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Match: got %+v, want %+v", got, want)
+	}
+	if _, err := p.Derive(`${user.name}`); err != nil {
+		t.Errorf("Derive with NameRunes failed: %v", err)
+	}
+	for _, bad := range []string{" ", "$", "}", "é", "\n"} {
+		if _, err := Parse(template, nil, NameRunes(bad)); err == nil {
+			t.Errorf("NameRunes(%q): got nil, want error", bad)
 		}
-	})
+	}
 }
 
-func TestSearch(t *testing.T) {
-	//                          1   1   2   2   2   3
-	//              0   4   8   2   6   0   4   8   2
-	const needle = `A1, B2, C3, D4, E5, F6, G7, H8, I9`
-	//              ^^              ^^              ^^
-	p := MustParse(`${x}${0}`, Binds{
-		{Name: "x", Expr: "[AEIOU]"}, {Name: "0", Expr: "[0-9]"},
-	})
+func TestInnerGroups(t *testing.T) {
+	binds := Binds{{"date", `(?P<year>\d{4})-(?P<month>\d{2})-(\d{2})`}}
+	p := MustParse(`on ${date}`, binds, InnerGroups())
+	got, err := p.Match("on 2024-05-17")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	want := Binds{{"date", "2024-05-17"}, {"date.year", "2024"}, {"date.month", "05"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Match: got %+v, want %+v", got, want)
+	}
 
-	t.Run("All", func(t *testing.T) {
-		want := map[string]int{"A1": 0, "E5": 16, "I9": 32}
-		got := make(map[string]int)
+	// Without the option, inner groups are not reported.
+	q := MustParse(`on ${date}`, binds)
+	if got, err := q.Match("on 2024-05-17"); err != nil || len(got) != 1 {
+		t.Errorf("Match without InnerGroups: got %+v, %v; want only date", got, err)
+	}
 
-		if err := p.Search(needle, func(i, j int, binds Binds) error {
-			// Check that the bound values are what the range contains.
-			a := binds.First("x") + binds.First("0")
-			b := needle[i:j]
+	// Inner groups do not disturb the alternatives of Any.
+	alt, err := Any(MustParse(`a${x}`, Binds{{"x", `(?P<k>\d)\d`}}, InnerGroups()), MustParse(`b${y}`, Binds{{"y", `\w`}}))
+	if err != nil {
+		t.Fatalf("Any failed: %v", err)
+	}
+	for _, test := range []struct {
+		needle string
+		want   Binds
+	}{
+		{"a12", Binds{{AnyWord, "0"}, {"x", "12"}, {"x.k", "1"}}},
+		{"bz", Binds{{AnyWord, "1"}, {"y", "z"}}},
+	} {
+		if got, err := alt.Match(test.needle); err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Any Match %q: got %+v, %v; want %+v", test.needle, got, err, test.want)
+		}
+	}
+}
 
-			if a != b {
-				t.Errorf("Search [%d:%d] bound %q ≠ indexed %q", i, j, a, b)
-			} else {
-				t.Logf("Search [%d:%d] bound=%q indexed=%q", i, j, a, b)
+func TestAlias(t *testing.T) {
+	p := MustParse(`<${first}>`, Binds{{"first", `\w+`}}, Alias("first", "copy"))
+	got, err := p.Match("<abc>")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if want := (Binds{{"first", "abc"}, {"copy", "abc"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Match: got %+v, want %+v", got, want)
+	}
+
+	// An occurrence of the alias uses the group and expression of its target.
+	q := MustParse(`${first}=${copy}`, Binds{{"first", `\d+`}}, Alias("first", "copy"), SameValue())
+	got, err = q.Match("12=12")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if got.First("first") != "12" || got.First("copy") != "12" {
+		t.Errorf("Match: got %+v, want first=copy=12", got)
+	}
+	for _, needle := range []string{"12=13", "12=ab"} {
+		if got, err := q.Match(needle); err != ErrNoMatch {
+			t.Errorf("Match %q: got %+v, %v; want %v", needle, got, err, ErrNoMatch)
+		}
+	}
+
+	// Without SameValue, the alias group still shares one value, which is
+	// reported once.
+	r := MustParse(`${a}=${b} ${a}`, Binds{{"a", `\d+`}}, Alias("a", "b"))
+	for _, test := range []struct {
+		needle string
+		want   Binds // nil for no match
+	}{
+		{"12=12 12", Binds{{"a", "12"}, {"b", "12"}}},
+		{"12=13 12", nil},
+		{"12=12 13", nil},
+	} {
+		got, err := r.Match(test.needle)
+		if test.want == nil {
+			if err != ErrNoMatch {
+				t.Errorf("Match %q: got %+v, %v; want %v", test.needle, got, err, ErrNoMatch)
 			}
-			got[a] = i
-			return nil
-		}); err != nil {
-			t.Errorf("Search %q failed: %v", needle, err)
+		} else if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Match %q: got %+v, %v; want %+v", test.needle, got, err, test.want)
 		}
-		if !reflect.DeepEqual(got, want) {
-			t.Errorf("Search %q:\n got: %+v\nwant: %+v", needle, got, want)
+		if mb, err := r.MatchBytes([]byte(test.needle)); !reflect.DeepEqual(mb, test.want) {
+			t.Errorf("MatchBytes %q: got %+v, %v; want %+v", test.needle, mb, err, test.want)
 		}
-	})
-
-	// Verify that a stop error is propagated as nil.
-	t.Run("StopEarly", func(t *testing.T) {
-		var found string
-		if err := p.Search(needle, func(i, j int, binds Binds) error {
-			found = needle[i:j]
-			return ErrStopSearch
-		}); err != nil {
-			t.Errorf("Search %q failed: %v", needle, err)
-		} else if found != "A1" {
-			t.Errorf("Search %q did not find A1", needle)
+		if ma, err := r.MatchApprox(test.needle, 0); !reflect.DeepEqual(ma, test.want) {
+			t.Errorf("MatchApprox %q: got %+v, %v; want %+v", test.needle, ma, err, test.want)
 		}
+	}
+	var found []string
+	r.Search("1=1 1, 2=3 2, 4=4 4", func(_, _ int, binds Binds) error {
+		found = append(found, binds.First("a"))
+		return nil
 	})
+	if want := []string{"1", "4"}; !reflect.DeepEqual(found, want) {
+		t.Errorf("Search: got %q, want %q", found, want)
+	}
 
-	// Verify that other errors generated by f get propagated.
-	t.Run("Errors", func(t *testing.T) {
-		want := errors.New("minions of bogosity")
-		got := p.Search(needle, func(i, j int, binds Binds) error {
-			t.Logf("Search [%d:%d] %q", i, j, needle[i:j])
+	// The expression of the target determines the length of the alias.
+	s := MustParse(`${a}-${b}`, Binds{{"a", `\d`}, {"b", `\d{5}`}}, Alias("a", "b"))
+	if got := s.MinLen(); got != 3 {
+		t.Errorf("MinLen: got %d, want 3", got)
+	}
+	if got, err := s.Match("1-1"); err != nil || got.First("b") != "1" {
+		t.Errorf("Match 1-1: got %+v, %v; want b=1", got, err)
+	}
+
+	// The target keeps its expression when only the alias is in the template,
+	// whether from Parse or from Derive.
+	want := Binds{{"a", "x"}, {"b", "x"}}
+	only := MustParse(`<${b}>`, Binds{{"a", `\w+`}}, Alias("a", "b"))
+	if got, err := only.Match("<x>"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Match alias only: got %+v, %v; want %+v", got, err, want)
+	}
+	d, err := MustParse(`${a}-${b}`, Binds{{"a", `\w+`}}, Alias("a", "b")).Derive(`<${b}>`)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	if got, err := d.Match("<x>"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Match derived: got %+v, %v; want %+v", got, err, want)
+	}
+}
+
+func TestSameValue(t *testing.T) {
+	binds := Binds{{"x", `\w+`}}
+	tests := []struct {
+		needle string
+		loose  bool // matches without SameValue
+		same   bool // matches with SameValue
+	}{
+		{"ab ab", true, true},
+		{"ab cd", true, false},
+		{"ab", false, false},
+	}
+	loose := MustParse(`${x} ${x}`, binds)
+	same := MustParse(`${x} ${x}`, binds, SameValue())
+	for _, test := range tests {
+		if _, err := loose.Match(test.needle); (err == nil) != test.loose {
+			t.Errorf("Match %q: got %v, want match=%v", test.needle, err, test.loose)
+		}
+		if _, err := same.Match(test.needle); (err == nil) != test.same {
+			t.Errorf("Match %q with SameValue: got %v, want match=%v", test.needle, err, test.same)
+		}
+		if _, err := same.Bind(nil).MatchBytes([]byte(test.needle)); (err == nil) != test.same {
+			t.Errorf("MatchBytes %q with SameValue: got %v, want match=%v", test.needle, err, test.same)
+		}
+	}
+}
+
+func TestRest(t *testing.T) {
+	p := MustParse(`${level} ${rest}`, Binds{{"level", `[A-Z]+`}, {"rest", Rest}})
+	got, err := p.Match("WARN disk is  nearly full\nretrying")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	want := Binds{{"level", "WARN"}, {"rest", "disk is  nearly full\nretrying"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Match: got %+v, want %+v", got, want)
+	}
+
+	var starts []int
+	if err := p.Search("x INFO a b\nERROR c", func(start, _ int, _ Binds) error {
+		starts = append(starts, start)
+		return nil
+	}); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if want := []int{2}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("Search: got starts %v, want %v", starts, want)
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	p := MustParse(`${k}=${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}})
+	binds, errs := p.MatchAll([]string{"a=1", "b=x", "cd=23"})
+	want := []Binds{{{"k", "a"}, {"v", "1"}}, nil, {{"k", "cd"}, {"v", "23"}}}
+	if !reflect.DeepEqual(binds, want) {
+		t.Errorf("MatchAll binds: got %+v, want %+v", binds, want)
+	}
+	if errs[0] != nil || errs[1] != ErrNoMatch || errs[2] != nil {
+		t.Errorf("MatchAll errors: got %v, want [nil %v nil]", errs, ErrNoMatch)
+	}
+
+	bad := MustParse(`${x}`, Binds{{"x", `(`}})
+	if _, errs := bad.MatchAll([]string{"a", "b"}); errs[0] == nil || errs[1] == nil {
+		t.Errorf("MatchAll invalid: got errors %v, want errors", errs)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		p      *P
+		needle string
+		want   bool
+	}{
+		{MustParse(`${k}=${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}}), "a=1", true},
+		{MustParse(`${k}=${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}}), "a=x", false},
+		{MustParse(`<${x}>`, nil), "<>", true},
+		{MustParse(`<${x}>`, nil), "<a>", false},
+
+		// The alias target "a" does not occur in the template, and is unbound,
+		// so it matches only empty text.
+		{MustParse(`[${b}]`, nil, Alias("a", "b")), "[]", true},
+		{MustParse(`[${b}]`, nil, Alias("a", "b")), "[b]", false},
+	}
+	for _, test := range tests {
+		got, err := test.p.Matches(test.needle)
+		if err != nil || got != test.want {
+			t.Errorf("Matches(%q, %q): got %v, %v; want %v, nil", test.p, test.needle, got, err, test.want)
+		}
+	}
+
+	bad := MustParse(`${x}`, Binds{{"x", `(`}})
+	if got, err := bad.Matches("a"); err == nil {
+		t.Errorf("Matches invalid: got %v, nil; want error", got)
+	}
+}
+
+func TestWordFlags(t *testing.T) {
+	p := MustParse(`${a}${b}-${a}`, Binds{{"a", `(?i)foo`}, {"b", `bar`}})
+	tests := []struct {
+		needle string
+		ok     bool
+	}{
+		{"foobar-foo", true},
+		{"FOObar-fOo", true},
+		{"fooBAR-foo", false},
+		{"FoObAr-foo", false},
+	}
+	for _, test := range tests {
+		got, err := p.Match(test.needle)
+		if test.ok && err != nil {
+			t.Errorf("Match %q failed: %v", test.needle, err)
+		} else if !test.ok && err != ErrNoMatch {
+			t.Errorf("Match %q: got %+v, %v; want %v", test.needle, got, err, ErrNoMatch)
+		}
+	}
+}
+
+func TestMatchFunc(t *testing.T) {
+	p := MustParse(`${name}: ${n}`, Binds{{"name", `\w+`}, {"n", `\d+`}})
+	got, err := p.MatchFunc("Alice: 007", func(name, value string) string {
+		if name == "n" {
+			return strings.TrimLeft(value, "0")
+		}
+		return strings.ToLower(value)
+	})
+	if err != nil {
+		t.Fatalf("MatchFunc failed: %v", err)
+	}
+	if want := (Binds{{"name", "alice"}, {"n", "7"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchFunc: got %+v, want %+v", got, want)
+	}
+}
+
+func TestMatchIndexedNames(t *testing.T) {
+	p := MustParse(`${x}, ${y}, ${x}, ${x}`, Binds{{"x", `\d+`}, {"y", `\w+`}})
+	got, err := p.MatchIndexedNames("1, a, 2, 3")
+	if err != nil {
+		t.Fatalf("MatchIndexedNames failed: %v", err)
+	}
+	want := Binds{{"x#1", "1"}, {"y#1", "a"}, {"x#2", "2"}, {"x#3", "3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MatchIndexedNames: got %+v, want %+v", got, want)
+	}
+	if got, err := p.MatchIndexedNames("1, a"); err != ErrNoMatch {
+		t.Errorf("MatchIndexedNames: got %+v, %v; want %v", got, err, ErrNoMatch)
+	}
+}
+
+func TestSeparatorClass(t *testing.T) {
+	p := MustParse(`foo-bar ${n}`, Binds{{"n", `\d+`}}, SeparatorClass("-_ "))
+	if got, want := p.String(), `foo-bar ${n}`; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+	tests := []struct {
+		needle string
+		ok     bool
+	}{
+		{"foo-bar 1", true},
+		{"foo_bar_2", true},
+		{"foo - bar__3", true},
+		{"foobar 4", false},
+		{"foo.bar 5", false},
+	}
+	d, err := p.Derive(`${n}:foo bar`)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	for _, test := range tests {
+		if _, err := p.Match(test.needle); (err == nil) != test.ok {
+			t.Errorf("Match %q: got %v, want match=%v", test.needle, err, test.ok)
+		}
+	}
+	if _, err := d.Match("5:foo-_bar"); err != nil {
+		t.Errorf("Match derived: %v", err)
+	}
+
+	// A run of separators in the template may match a shorter run.
+	if _, err := MustParse(`a - b`, nil, SeparatorClass("- ")).Match("a-b"); err != nil {
+		t.Errorf("Match shorter separator: %v", err)
+	}
+
+	// MinLen counts each run of separators as a single character, so that a
+	// needle shorter than the template is not rejected before matching.
+	for _, test := range []struct {
+		template string
+		want     int
+	}{
+		{`a - b`, 3},
+		{`a---b ${n}`, 5},
+		{`--`, 1},
+		{`ab`, 2},
+	} {
+		r := MustParse(test.template, Binds{{"n", `\d`}}, SeparatorClass("- "))
+		if got := r.MinLen(); got != test.want {
+			t.Errorf("MinLen %q: got %d, want %d", test.template, got, test.want)
+		}
+	}
+	if _, err := MustParse(`a---b ${n}`, Binds{{"n", `\d`}}, SeparatorClass("- ")).Match("a-b-1"); err != nil {
+		t.Errorf("Match short needle: %v", err)
+	}
+
+	// Metacharacters in the class are handled.
+	q := MustParse(`a.b`, nil, SeparatorClass(".]^\\"))
+	if _, err := q.Match(`a]^\.b`); err != nil {
+		t.Errorf("Match with metacharacter class: %v", err)
+	}
+}
+
+func TestMatchAnchor(t *testing.T) {
+	binds := Binds{{"n", `\d+`}}
+	tests := []struct {
+		opts   []Option
+		needle string
+		want   string // "" for no match
+	}{
+		{nil, "x12", "12"},
+		{nil, "x12 x3", ""},
+		{[]Option{AnchorStart(), AnchorEnd()}, "x12 x3", ""},
+		{[]Option{AnchorStart()}, "x12 x3", "12"},
+		{[]Option{AnchorStart()}, "y x12", ""},
+		{[]Option{AnchorEnd()}, "x12 x3", "3"},
+		{[]Option{AnchorEnd()}, "x12 y", ""},
+	}
+	for _, test := range tests {
+		p := MustParse(`x${n}`, binds, test.opts...)
+		m, err := p.Match(test.needle)
+		if test.want == "" {
+			if err != ErrNoMatch {
+				t.Errorf("Match %q (%d opts): got %+v, %v; want %v", test.needle, len(test.opts), m, err, ErrNoMatch)
+			}
+		} else if err != nil {
+			t.Errorf("Match %q (%d opts) failed: %v", test.needle, len(test.opts), err)
+		} else if got := m.First("n"); got != test.want {
+			t.Errorf("Match %q (%d opts): got %q, want %q", test.needle, len(test.opts), got, test.want)
+		}
+	}
+
+	// Anchoring is preserved by Bind.
+	p := MustParse(`x${n}`, nil, AnchorStart()).Bind(binds)
+	if m, err := p.Match("x5 and more"); err != nil || m.First("n") != "5" {
+		t.Errorf("Match after Bind: got %+v, %v; want n=5", m, err)
+	}
+}
+
+func TestMatchReader(t *testing.T) {
+	p := MustParse("${a}:${b}\n", Binds{{"a", `\w+`}, {"b", `\d+`}})
+	m, err := p.MatchReader(strings.NewReader("key:25\n"))
+	if err != nil {
+		t.Fatalf("MatchReader failed: %v", err)
+	}
+	if want := (Binds{{"a", "key"}, {"b", "25"}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("MatchReader: got %+v, want %+v", m, want)
+	}
+	if m, err := p.MatchReader(strings.NewReader("key:25\nmore\n")); err != ErrNoMatch {
+		t.Errorf("MatchReader: got %+v, %v; want %v", m, err, ErrNoMatch)
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	t.Run("BadCompile", func(t *testing.T) {
+		p := MustParse(`arg${vowel}naut`, []Bind{{"vowel", "[bad"}})
+		m, err := p.Match("it got better")
+		if err == nil {
+			t.Errorf("Match: got %+v, wanted error", m)
+		} else {
+			t.Logf("Match: correctly failed: %v", err)
+		}
+	})
+	t.Run("ErrNoMatch", func(t *testing.T) {
+		p := MustParse(`arg${vowel}naut`, []Bind{{"vowel", "(?i)[aeiou]"}})
+		for _, test := range []string{
+			"",           // no match
+			"argo",       // incomplete match
+			"naut",       // "
+			" argonaut ", // match does not consume the whole string
+		} {
+			m, err := p.Match(test)
+			if err == nil {
+				t.Errorf("Match %q: got %+v, wanted error", test, m)
+			} else {
+				t.Logf("Match %q: correctly failed: %v", test, err)
+			}
+		}
+	})
+	t.Run("NoBinding", func(t *testing.T) {
+		p := MustParse(`arg${o}naut`, nil)
+		m, err := p.Match("argonaut")
+		if err == nil {
+			t.Errorf("Match: got %+v, wanted error", m)
+		} else {
+			t.Logf("Match correctly failed: %v", err)
+		}
+	})
+}
+
+func TestLazy(t *testing.T) {
+	binds := Binds{{"text", `.*`}}
+	const needle = `<b>one</b> and <b>two</b>`
+
+	greedy := MustParse(`<b>${text}</b>`, binds)
+	lazy := MustParse(`<b>${text?}</b>`, binds)
+	if got := lazy.Binds(); !reflect.DeepEqual(got, Binds{{"text", `.*`}}) {
+		t.Errorf("Binds: got %+v, want text", got)
+	}
+
+	for _, test := range []struct {
+		p    *P
+		want []string
+	}{
+		{greedy, []string{"one</b> and <b>two"}},
+		{lazy, []string{"one", "two"}},
+		{lazy.Bind(nil), []string{"one", "two"}},
+	} {
+		var got []string
+		if err := test.p.Search(needle, func(_, _ int, binds Binds) error {
+			got = append(got, binds.First("text"))
+			return nil
+		}); err != nil {
+			t.Errorf("Search %q failed: %v", test.p, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search %q: got %+q, want %+q", test.p, got, test.want)
+		}
+	}
+
+	// Lazy words can still match the whole needle.
+	if m, err := lazy.Match(`<b>x</b>y</b>`); err != nil || m.First("text") != "x</b>y" {
+		t.Errorf("Match: got %+v, %v; want text=x</b>y", m, err)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	p := MustParse(`${key}=${value};${other}`, Binds{
+		{"key", `\s*\w+\s*`}, {"value", `[^;]*`}, {"other", `.*`},
+	}).Trim("key", "value", "nonesuch")
+
+	const needle = "  foo  =  bar baz ; quux "
+	got, err := p.Match(needle)
+	if err != nil {
+		t.Fatalf("Match %q failed: %v", needle, err)
+	}
+	want := Binds{{"key", "foo"}, {"value", "bar baz"}, {"other", " quux "}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Match %q:\ngot:  %+v\nwant: %+v", needle, got, want)
+	}
+
+	// Verify that trimming survives rebinding and derivation.
+	q, err := p.Bind(Binds{{"other", `\w+`}}).Derive(`${value}:${other}`)
+	if err != nil {
+		t.Fatalf("Derive failed: %v", err)
+	}
+	got, err = q.Match(" x :y")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	want = Binds{{"value", "x"}, {"other", "y"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Match:\ngot:  %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	//                          1   1   2   2   2   3
+	//              0   4   8   2   6   0   4   8   2
+	const needle = `A1, B2, C3, D4, E5, F6, G7, H8, I9`
+	//              ^^              ^^              ^^
+	p := MustParse(`${x}${0}`, Binds{
+		{Name: "x", Expr: "[AEIOU]"}, {Name: "0", Expr: "[0-9]"},
+	})
+
+	t.Run("All", func(t *testing.T) {
+		want := map[string]int{"A1": 0, "E5": 16, "I9": 32}
+		got := make(map[string]int)
+
+		if err := p.Search(needle, func(i, j int, binds Binds) error {
+			// Check that the bound values are what the range contains.
+			a := binds.First("x") + binds.First("0")
+			b := needle[i:j]
+
+			if a != b {
+				t.Errorf("Search [%d:%d] bound %q ≠ indexed %q", i, j, a, b)
+			} else {
+				t.Logf("Search [%d:%d] bound=%q indexed=%q", i, j, a, b)
+			}
+			got[a] = i
+			return nil
+		}); err != nil {
+			t.Errorf("Search %q failed: %v", needle, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Search %q:\n got: %+v\nwant: %+v", needle, got, want)
+		}
+	})
+
+	// Verify that a stop error is propagated as nil.
+	t.Run("StopEarly", func(t *testing.T) {
+		var found string
+		if err := p.Search(needle, func(i, j int, binds Binds) error {
+			found = needle[i:j]
+			return ErrStopSearch
+		}); err != nil {
+			t.Errorf("Search %q failed: %v", needle, err)
+		} else if found != "A1" {
+			t.Errorf("Search %q did not find A1", needle)
+		}
+	})
+
+	// Verify that other errors generated by f get propagated.
+	t.Run("Errors", func(t *testing.T) {
+		want := errors.New("minions of bogosity")
+		got := p.Search(needle, func(i, j int, binds Binds) error {
+			t.Logf("Search [%d:%d] %q", i, j, needle[i:j])
 			if binds.First("x") == "E" {
 				return want
 			}
 			return nil
 		})
-		if got != want {
-			t.Errorf("Search %q: got error %v, want %v", needle, got, want)
+		if got != want {
+			t.Errorf("Search %q: got error %v, want %v", needle, got, want)
+		}
+	})
+}
+
+func TestAll(t *testing.T) {
+	p := MustParse(`${k}=${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}})
+	const needle = "a=1, b=2, c=3"
+
+	var got []string
+	for start, binds := range p.All(needle) {
+		got = append(got, fmt.Sprintf("%d:%s", start, binds.First("k")))
+	}
+	if want := []string{"0:a", "5:b", "10:c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("All: got %+q, want %+q", got, want)
+	}
+
+	var n int
+	for range p.All(needle) {
+		n++
+		if n == 2 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("All with break: got %d matches, want 2", n)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	p := MustParse(`<${n}>`, Binds{{"n", `\d+`}})
+	tests := []struct {
+		needle string
+		want   []string
+	}{
+		{"", []string{""}},
+		{"abc", []string{"abc"}},
+		{"a<1>b<22>c", []string{"a", "b", "c"}},
+		{"<1>a<2>", []string{"", "a", ""}},
+		{"a<1><2>b", []string{"a", "", "b"}},
+		{"a<x>b", []string{"a<x>b"}},
+	}
+	for _, test := range tests {
+		got := p.Split(test.needle)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Split %q: got %+q, want %+q", test.needle, got, test.want)
+		}
+		re := regexp.MustCompile(`<\d+>`)
+		if want := re.Split(test.needle, -1); !reflect.DeepEqual(got, want) {
+			t.Errorf("Split %q: got %+q, regexp.Split gives %+q", test.needle, got, want)
+		}
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	p := MustParse(`${d}`, Binds{{"d", `\d{1,3}`}})
+	tests := []struct {
+		needle string
+		want   [][2]int
+	}{
+		{"", nil},
+		{"none", nil},
+		{"a12b", [][2]int{{1, 3}}},
+		{"123456 78", [][2]int{{0, 6}, {7, 9}}},
+		{"1a2b3", [][2]int{{0, 1}, {2, 3}, {4, 5}}},
+	}
+	for _, test := range tests {
+		if got := p.Coverage(test.needle); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Coverage %q: got %v, want %v", test.needle, got, test.want)
+		}
+	}
+}
+
+func TestFindLast(t *testing.T) {
+	p := MustParse(`[${ts}]`, Binds{{"ts", `\d\d:\d\d`}})
+	const needle = "[10:15] start [10:16] middle [10:20] end"
+	start, end, binds, err := p.FindLast(needle)
+	if err != nil {
+		t.Fatalf("FindLast failed: %v", err)
+	}
+	if start != 29 || end != 36 || binds.First("ts") != "10:20" {
+		t.Errorf("FindLast: got %d, %d, %+v; want 29, 36, ts=10:20", start, end, binds)
+	}
+	if _, _, binds, err := p.FindLast("no times here"); err != ErrNoMatch {
+		t.Errorf("FindLast: got %+v, %v; want %v", binds, err, ErrNoMatch)
+	}
+}
+
+func TestFill(t *testing.T) {
+	p := MustParse(`${a} and ${b} and ${a}`, nil)
+	var calls []string
+	got, err := p.Fill(func(name string, index int) (string, error) {
+		calls = append(calls, fmt.Sprintf("%s%d", name, index))
+		return strings.ToUpper(name) + strconv.Itoa(index), nil
+	})
+	if err != nil {
+		t.Fatalf("Fill failed: %v", err)
+	}
+	if want := "A0 and B1 and A2"; got != want {
+		t.Errorf("Fill: got %q, want %q", got, want)
+	}
+	if want := []string{"a0", "b1", "a2"}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("Fill calls: got %q, want %q", calls, want)
+	}
+
+	if got, err := p.Fill(func(name string, _ int) (string, error) {
+		if name == "b" {
+			return "", errors.New("bad")
+		}
+		return name, nil
+	}); err == nil {
+		t.Errorf("Fill: got %q, want error", got)
+	}
+}
+
+func TestContainsAll(t *testing.T) {
+	p := MustParse(`${id}: ${mail}`, Binds{{"id", `\d{3}`}, {"mail", `\w+@\w+`}})
+	for _, test := range []struct {
+		needle string
+		want   bool
+	}{
+		{"123: a@b", true},
+		{"a@b and later 123", true},
+		{"a@b and 12", false},
+		{"123 only", false},
+		{"", false},
+	} {
+		if got := p.ContainsAll(test.needle); got != test.want {
+			t.Errorf("ContainsAll %q: got %v, want %v", test.needle, got, test.want)
+		}
+	}
+	// The compiled expressions are cached, but not shared with a rebound copy.
+	if !p.ContainsAll("123: a@b") {
+		t.Error("ContainsAll repeated: got false, want true")
+	}
+	if q := p.Bind(Binds{{"id", `\d{4}`}}); q.ContainsAll("123: a@b") {
+		t.Error("ContainsAll rebound: got true, want false")
+	}
+	if !MustParse("static", nil).ContainsAll("anything") {
+		t.Error("ContainsAll static: got false, want true")
+	}
+	if MustParse(`${x}`, Binds{{"x", `(`}}).ContainsAll("(") {
+		t.Error("ContainsAll invalid: got true, want false")
+	}
+	if !MustParse(`${x}`, Binds{{"x", `strasse`}}, UnicodeFold()).ContainsAll("in der STRAẞE") {
+		t.Error("ContainsAll UnicodeFold: got false, want true")
+	}
+	if !MustParse(`${x}`, Binds{{"x", `a.b`}}, DotAll()).ContainsAll("a\nb") {
+		t.Error("ContainsAll DotAll: got false, want true")
+	}
+}
+
+func TestCount(t *testing.T) {
+	p := MustParse(`[${ts}]`, Binds{{"ts", `\d\d:\d\d`}})
+	for _, test := range []struct {
+		needle string
+		want   int
+	}{
+		{"", 0},
+		{"no times here", 0},
+		{"[10:15] start", 1},
+		{"[10:15] start [10:16] middle [10:20] end", 3},
+	} {
+		got, err := p.Count(test.needle)
+		if err != nil {
+			t.Errorf("Count %q failed: %v", test.needle, err)
+		} else if got != test.want {
+			t.Errorf("Count %q: got %d, want %d", test.needle, got, test.want)
+		}
+	}
+
+	q := MustParse(`${n}`, Binds{{"n", `\d*`}}, NoEmpty())
+	if got, err := q.Count("1,23,"); err != nil || got != 2 {
+		t.Errorf("Count with NoEmpty: got %d, %v; want 2, nil", got, err)
+	}
+	neg := MustParse(`id=${!bad}${id}`, Binds{{"bad", `admin`}, {"id", `\w+`}})
+	if got, err := neg.Count("id=alice id=admin id=bob"); err != nil || got != 2 {
+		t.Errorf("Count with negative word: got %d, %v; want 2, nil", got, err)
+	}
+	alias := MustParse(`${a}-${b}`, Binds{{"a", `\d`}, {"b", `\d`}}, Alias("a", "b"))
+	if got, err := alias.Count("1-1 2-3 4-4"); err != nil || got != 2 {
+		t.Errorf("Count with Alias: got %d, %v; want 2, nil", got, err)
+	}
+	if _, err := MustParse(`${x}`, Binds{{"x", `(`}}).Count("x"); err == nil {
+		t.Error("Count with invalid expression: got nil, want error")
+	}
+}
+
+func TestNoEmpty(t *testing.T) {
+	binds := Binds{{"n", `\d*`}}
+	for _, test := range []struct {
+		opts []Option
+		want []string
+	}{
+		{nil, []string{"1", "23", ""}},
+		{[]Option{NoEmpty()}, []string{"1", "23"}},
+	} {
+		p := MustParse(`${n}`, binds, test.opts...)
+		var got []string
+		if err := p.Search("1,23,", func(_, _ int, binds Binds) error {
+			got = append(got, binds.First("n"))
+			return nil
+		}); err != nil {
+			t.Errorf("Search failed: %v", err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Search (%d opts): got %+q, want %+q", len(test.opts), got, test.want)
+		}
+	}
+	p := MustParse(`${n}`, binds, NoEmpty())
+	if start, end, _, err := p.FindLast("1,23,"); err != nil || start != 2 || end != 4 {
+		t.Errorf("FindLast: got %d, %d, %v; want 2, 4, nil", start, end, err)
+	}
+}
+
+func TestSearchLines(t *testing.T) {
+	const needle = "key = 1\nkey = 2 # comment\n  key = 3\nkey = 4"
+	p := MustParse(`${k} = ${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}})
+
+	var got []string
+	if err := p.SearchLines(needle, func(i, j int, binds Binds) error {
+		t.Logf("SearchLines [%d:%d] %q", i, j, needle[i:j])
+		got = append(got, binds.First("v"))
+		return nil
+	}); err != nil {
+		t.Fatalf("SearchLines %q failed: %v", needle, err)
+	}
+	if want := []string{"1", "4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchLines %q:\n got: %+q\nwant: %+q", needle, got, want)
+	}
+}
+
+func TestSearchContext(t *testing.T) {
+	p := MustParse(`err=${code}`, Binds{{"code", `\d+`}})
+	const needle = "a err=1 bbbbbbb err=22 c"
+	var got []string
+	if err := p.SearchContext(needle, 3, 4, func(ctx string, binds Binds) error {
+		got = append(got, ctx+"|"+binds.First("code"))
+		return nil
+	}); err != nil {
+		t.Fatalf("SearchContext failed: %v", err)
+	}
+	want := []string{"a err=1 bbb|1", "bb err=22 c|22"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SearchContext: got %+q, want %+q", got, want)
+	}
+}
+
+func TestSearchContiguous(t *testing.T) {
+	p := MustParse(`${tok} `, Binds{{"tok", `\w+`}})
+	tests := []struct {
+		needle string
+		want   []string
+		ok     bool
+	}{
+		{"", nil, true},
+		{"a bb ccc ", []string{"a", "bb", "ccc"}, true},
+		{"a bb  ccc ", []string{"a", "bb"}, false},
+		{"a bb ccc", []string{"a", "bb"}, false},
+		{"-a ", nil, false},
+	}
+	for _, test := range tests {
+		var got []string
+		err := p.SearchContiguous(test.needle, func(_, _ int, binds Binds) error {
+			got = append(got, binds.First("tok"))
+			return nil
+		})
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("SearchContiguous %q: got error %v, want ok=%v", test.needle, err, test.ok)
 		}
-	})
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SearchContiguous %q: got %+q, want %+q", test.needle, got, test.want)
+		}
+	}
+
+	// Stopping early does not report the unmatched remainder.
+	var n int
+	if err := p.SearchContiguous("a b c !", func(_, _ int, _ Binds) error {
+		n++
+		if n == 2 {
+			return ErrStopSearch
+		}
+		return nil
+	}); err != nil || n != 2 {
+		t.Errorf("SearchContiguous with stop: got %d, %v; want 2, nil", n, err)
+	}
 }
 
 func TestApply(t *testing.T) {
@@ -314,6 +1456,294 @@ func TestApply(t *testing.T) {
 	}
 }
 
+func TestWordOffsets(t *testing.T) {
+	const template = `$$${a} and ${long_name?}${a}.`
+	p := MustParse(template, nil)
+	got := p.WordOffsets()
+	want := []WordPos{{"a", 2, 6}, {"long_name", 11, 24}, {"a", 24, 28}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WordOffsets: got %+v, want %+v", got, want)
+	}
+	for _, w := range got {
+		if s := template[w.Start:w.End]; !strings.HasPrefix(s, "${"+w.Name) {
+			t.Errorf("WordOffsets %+v: covers %q", w, s)
+		}
+	}
+	if got := MustParse("static", nil).WordOffsets(); got != nil {
+		t.Errorf("WordOffsets static: got %+v, want nil", got)
+	}
+}
+
+func TestLiteralPattern(t *testing.T) {
+	for _, s := range []string{"", "plain", "${a} costs $5", "{$}$$"} {
+		p := Literal(s)
+		if !p.IsStatic() {
+			t.Errorf("Literal(%q): pattern is not static", s)
+		}
+		if got, err := p.Match(s); err != nil || len(got) != 0 {
+			t.Errorf("Literal(%q) Match: got %+v, %v; want no bindings", s, got, err)
+		}
+		if got, err := p.Match(s + "x"); err != ErrNoMatch {
+			t.Errorf("Literal(%q) Match extra: got %+v, %v; want %v", s, got, err, ErrNoMatch)
+		}
+		if got, err := p.Apply(nil); err != nil || got != s {
+			t.Errorf("Literal(%q) Apply: got %q, %v; want %q", s, got, err, s)
+		}
+		if q := MustParse(p.String(), nil); !q.Equivalent(p) {
+			t.Errorf("Literal(%q): parsing %q does not give an equivalent pattern", s, p.String())
+		}
+	}
+}
+
+func TestApplyRecursive(t *testing.T) {
+	p := MustParse(`url: ${url}`, nil)
+	binds := Binds{
+		{"url", "${scheme}://${host}/${path}"},
+		{"host", "${name}.${domain}"},
+		{"scheme", "https"},
+		{"name", "www"},
+		{"domain", "example.com"},
+		{"path", "cost$5"},
+	}
+	const want = "url: https://www.example.com/cost$5"
+	if got, err := p.ApplyRecursive(binds, 2); err != nil || got != want {
+		t.Errorf("ApplyRecursive: got %q, %v; want %q", got, err, want)
+	}
+	if got, err := p.ApplyRecursive(binds, 1); err == nil {
+		t.Errorf("ApplyRecursive depth 1: got %q, want error", got)
+	}
+
+	// "$$" is unescaped the same way whether or not a value has pattern words.
+	esc := Binds{{"url", "${a} $$${b}"}, {"a", "$$1"}, {"b", "2"}}
+	if got, err := p.ApplyRecursive(esc, 2); err != nil || got != "url: $1 $2" {
+		t.Errorf("ApplyRecursive escapes: got %q, %v; want %q", got, err, "url: $1 $2")
+	}
+
+	cycle := Binds{{"url", "${a}"}, {"a", "${b}"}, {"b", "x${a}"}}
+	if got, err := p.ApplyRecursive(cycle, 10); err == nil {
+		t.Errorf("ApplyRecursive cycle: got %q, want error", got)
+	}
+	missing := Binds{{"url", "${nonesuch}"}}
+	if got, err := p.ApplyRecursive(missing, 10); err == nil {
+		t.Errorf("ApplyRecursive missing: got %q, want error", got)
+	}
+}
+
+func TestRequireBindings(t *testing.T) {
+	p := MustParse(`${a} ${b} ${c} ${b}`, nil)
+	if err := p.RequireBindings(map[string]bool{"a": true, "b": true, "c": true, "d": true}); err != nil {
+		t.Errorf("RequireBindings: unexpected error: %v", err)
+	}
+	err := p.RequireBindings(map[string]bool{"a": true, "c": false})
+	if err == nil {
+		t.Fatal("RequireBindings: got nil, want error")
+	}
+	if got, want := err.Error(), `no bindings for ["b" "c"]`; got != want {
+		t.Errorf("RequireBindings: got %q, want %q", got, want)
+	}
+	if err := MustParse("static", nil).RequireBindings(nil); err != nil {
+		t.Errorf("RequireBindings static: unexpected error: %v", err)
+	}
+}
+
+func TestApplyUsed(t *testing.T) {
+	p := MustParse(`${a} ${b} ${a} ${a}`, nil)
+	binds := Binds{{"c", "0"}, {"a", "1"}, {"b", "2"}, {"a", "3"}, {"b", "4"}}
+	got, used, err := p.ApplyUsed(binds)
+	if err != nil {
+		t.Fatalf("ApplyUsed failed: %v", err)
+	}
+	if want := "1 2 3 3"; got != want {
+		t.Errorf("ApplyUsed: got %q, want %q", got, want)
+	}
+	if want := []Bind{{"a", "1"}, {"b", "2"}, {"a", "3"}}; !reflect.DeepEqual(used, want) {
+		t.Errorf("ApplyUsed used: got %+v, want %+v", used, want)
+	}
+	if _, _, err := p.ApplyUsed(Binds{{"a", "1"}}); err == nil {
+		t.Error("ApplyUsed with missing binding: got nil, want error")
+	}
+}
+
+func TestCanApply(t *testing.T) {
+	p := MustParse(`${a} ${b} ${a}`, nil)
+	tests := []struct {
+		binds Binds
+		want  bool
+	}{
+		{Binds{{"a", "1"}, {"b", "2"}}, true},
+		{Binds{{"b", "2"}, {"a", "1"}, {"c", "3"}}, true},
+		{Binds{{"a", "1"}}, false},
+		{nil, false},
+	}
+	for _, test := range tests {
+		got := p.CanApply(test.binds)
+		if got != test.want {
+			t.Errorf("CanApply %+v: got %v, want %v", test.binds, got, test.want)
+		}
+		if _, err := p.Apply(test.binds); (err == nil) != got {
+			t.Errorf("Apply %+v: got error %v, but CanApply reports %v", test.binds, err, got)
+		}
+	}
+	if !MustParse("static", nil).CanApply(nil) {
+		t.Error("CanApply static: got false, want true")
+	}
+}
+
+func TestCheckRoundTrip(t *testing.T) {
+	p := MustParse(`${a}-${b}`, Binds{{"a", `.+`}, {"b", `.+`}})
+	tests := []struct {
+		binds Binds
+		ok    bool
+	}{
+		{Binds{{"a", "x"}, {"b", "y"}}, true},
+		{Binds{{"a", "x"}, {"b", "y-z"}}, false}, // greedy a takes "x-y"
+		{Binds{{"a", "x"}}, false},               // missing binding
+		{Binds{{"a", ""}, {"b", "y"}}, false},    // does not match .+
+	}
+	for _, test := range tests {
+		err := p.CheckRoundTrip(test.binds)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("CheckRoundTrip %+v: got %v, want ok=%v", test.binds, err, test.ok)
+		} else if err != nil {
+			t.Logf("CheckRoundTrip %+v: correctly failed: %v", test.binds, err)
+		}
+	}
+}
+
+func TestApplyConsumeAll(t *testing.T) {
+	p := MustParse(`${a} ${b} ${a}`, nil)
+	tests := []struct {
+		binds Binds
+		want  string
+		ok    bool
+	}{
+		{Binds{{"a", "1"}, {"b", "2"}, {"a", "3"}}, "1 2 3", true},
+		{Binds{{"a", "1"}, {"b", "2"}}, "1 2 1", true},
+		{Binds{{"a", "1"}, {"b", "2"}, {"c", "x"}, {"c", "y"}}, "1 2 1", true},
+		{Binds{{"a", "1"}, {"b", "2"}, {"a", "3"}, {"a", "4"}}, "", false},
+		{Binds{{"a", "1"}, {"b", "2"}, {"b", "3"}}, "", false},
+		{Binds{{"a", "1"}}, "", false},
+	}
+	for _, test := range tests {
+		got, err := p.ApplyConsumeAll(test.binds)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("ApplyConsumeAll %+v: got %q, wanted error", test.binds, got)
+			}
+		} else if err != nil {
+			t.Errorf("ApplyConsumeAll %+v failed: %v", test.binds, err)
+		} else if got != test.want {
+			t.Errorf("ApplyConsumeAll %+v: got %q, want %q", test.binds, got, test.want)
+		}
+	}
+}
+
+func TestAppendApply(t *testing.T) {
+	p := MustParse(`${a}-${b}-${a};`, nil)
+	buf := []byte("start:")
+	buf, err := p.AppendApply(buf, Binds{{"a", "1"}, {"b", "2"}})
+	if err != nil {
+		t.Fatalf("AppendApply failed: %v", err)
+	}
+	buf, err = p.AppendApply(buf, Binds{{"a", "3"}, {"b", "4"}, {"a", "5"}})
+	if err != nil {
+		t.Fatalf("AppendApply failed: %v", err)
+	}
+	if got, want := string(buf), "start:1-2-1;3-4-5;"; got != want {
+		t.Errorf("AppendApply: got %q, want %q", got, want)
+	}
+	if got, err := p.AppendApply(buf, Binds{{"a", "6"}}); err == nil {
+		t.Errorf("AppendApply: got %q, wanted error", got)
+	} else if string(got) != string(buf) {
+		t.Errorf("AppendApply: got %q after error, want %q", got, buf)
+	}
+}
+
+func TestApplyEscape(t *testing.T) {
+	p := MustParse(`<a href="${url}">${text}</a> <b>${text}</b>`, nil)
+	got, err := p.ApplyEscape(Binds{
+		{"url", "/x?a=1&b=2"}, {"text", `"<hi>"`},
+	}, html.EscapeString)
+	if err != nil {
+		t.Fatalf("ApplyEscape failed: %v", err)
+	}
+	const want = `<a href="/x?a=1&amp;b=2">&#34;&lt;hi&gt;&#34;</a> <b>&#34;&lt;hi&gt;&#34;</b>`
+	if got != want {
+		t.Errorf("ApplyEscape: got %q, want %q", got, want)
+	}
+}
+
+func TestApplyValues(t *testing.T) {
+	p := MustParse(`${a} ${b} ${a}!`, nil)
+	tests := []struct {
+		vals []string
+		want string
+		ok   bool
+	}{
+		{[]string{"x", "y", "z"}, "x y z!", true},
+		{[]string{"x", "y", "z", "w"}, "x y z!", true},
+		{[]string{"x", "y"}, "", false},
+		{nil, "", false},
+	}
+	for _, test := range tests {
+		got, err := p.ApplyValues(test.vals)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("ApplyValues %+q: got %q, wanted error", test.vals, got)
+			}
+		} else if err != nil {
+			t.Errorf("ApplyValues %+q failed: %v", test.vals, err)
+		} else if got != test.want {
+			t.Errorf("ApplyValues %+q: got %q, want %q", test.vals, got, test.want)
+		}
+	}
+}
+
+func TestApplyFuncTrim(t *testing.T) {
+	p := MustParse(`SELECT * FROM t WHERE a = 1 AND b = ${b} AND c = ${c};`, nil)
+	tests := []struct {
+		vals map[string]string
+		want string
+	}{
+		{map[string]string{"b": "2", "c": "3"}, "SELECT * FROM t WHERE a = 1 AND b = 2 AND c = 3;"},
+		{map[string]string{"b": "2"}, "SELECT * FROM t WHERE a = 1 AND b = 2;"},
+		{map[string]string{"c": "3"}, " AND c = 3;"},
+		{nil, ";"},
+	}
+	for _, test := range tests {
+		got, err := p.ApplyFuncTrim(func(name string, _ int) (string, bool, error) {
+			v, ok := test.vals[name]
+			return v, ok, nil
+		})
+		if err != nil {
+			t.Errorf("ApplyFuncTrim %v failed: %v", test.vals, err)
+		} else if got != test.want {
+			t.Errorf("ApplyFuncTrim %v: got %q, want %q", test.vals, got, test.want)
+		}
+	}
+}
+
+func TestTemplateFunc(t *testing.T) {
+	tmpl := template.Must(template.New("test").Funcs(template.FuncMap{
+		"pair": MustParse(`(${x}, ${y})`, nil).TemplateFunc(),
+	}).Parse(`{{range .}}{{pair "x" .X "y" .Y}} {{end}}`))
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, []struct{ X, Y int }{{1, 2}, {3, 4}}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if got, want := buf.String(), "(1, 2) (3, 4) "; got != want {
+		t.Errorf("Execute: got %q, want %q", got, want)
+	}
+
+	f := MustParse(`${x}`, nil).TemplateFunc()
+	for _, args := range [][]interface{}{{"x"}, {1, "x"}, {"y", 1}} {
+		if got, err := f(args...); err == nil {
+			t.Errorf("TemplateFunc %v: got %q, wanted error", args, got)
+		}
+	}
+}
+
 func TestApplyFunc(t *testing.T) {
 	p := MustParse(`${a} ${b} ${a} ${a} ${b} ${_c} f`, nil)
 
@@ -337,6 +1767,67 @@ func TestApplyFunc(t *testing.T) {
 	}
 }
 
+func TestApplyDefaults(t *testing.T) {
+	p := MustParse(`${host}:${port}/${host}`, nil)
+	got, err := p.ApplyDefaults(map[string]string{"host": "localhost", "port": "80", "x": "y"})
+	if err != nil {
+		t.Fatalf("ApplyDefaults failed: %v", err)
+	}
+	if want := "localhost:80/localhost"; got != want {
+		t.Errorf("ApplyDefaults: got %q, want %q", got, want)
+	}
+	if got, err := p.ApplyDefaults(map[string]string{"host": "localhost"}); err == nil {
+		t.Errorf("ApplyDefaults: got %q, wanted error", got)
+	}
+}
+
+func TestApplyIndexed(t *testing.T) {
+	p := MustParse(`${f}(${arg}, ${arg}, ${arg})`, nil)
+	tests := []struct {
+		base map[string]string
+		sep  string
+		want string
+	}{
+		{map[string]string{"f": "call", "arg": "x"}, "", "call(x1, x2, x3)"},
+		{map[string]string{"f": "call", "arg": "x"}, "_", "call(x_1, x_2, x_3)"},
+	}
+	for _, test := range tests {
+		got, err := p.ApplyIndexed(test.base, test.sep)
+		if err != nil {
+			t.Errorf("ApplyIndexed %v %q failed: %v", test.base, test.sep, err)
+		} else if got != test.want {
+			t.Errorf("ApplyIndexed %v %q: got %q, want %q", test.base, test.sep, got, test.want)
+		}
+	}
+	if got, err := p.ApplyIndexed(map[string]string{"f": "g"}, ""); err == nil {
+		t.Errorf("ApplyIndexed: got %q, wanted error", got)
+	}
+}
+
+func TestEnvBindFunc(t *testing.T) {
+	t.Setenv("PATTERN_TEST_host", "example.com")
+	t.Setenv("PATTERN_TEST_port", "")
+	p := MustParse(`${host}:${port}`, nil)
+
+	got, err := p.ApplyFunc(EnvBindFunc("PATTERN_TEST_"))
+	if err != nil {
+		t.Fatalf("ApplyFunc failed: %v", err)
+	}
+	if want := "example.com:"; got != want {
+		t.Errorf("ApplyFunc: got %q, want %q", got, want)
+	}
+
+	q := MustParse(`${host}/${path}`, nil)
+	if got, err := q.ApplyFunc(EnvBindFunc("PATTERN_TEST_")); err == nil {
+		t.Errorf("ApplyFunc with unset variable: got %q, wanted error", got)
+	}
+	if got, err := q.ApplyFunc(EnvOrEmptyBindFunc("PATTERN_TEST_")); err != nil {
+		t.Errorf("ApplyFunc with unset variable failed: %v", err)
+	} else if want := "example.com/"; got != want {
+		t.Errorf("ApplyFunc with unset variable: got %q, want %q", got, want)
+	}
+}
+
 func TestRoundTrip(t *testing.T) {
 	// Verify that the bindings from a match can be applied to recover the
 	// original string.
@@ -397,3 +1888,278 @@ func TestRoundTrip(t *testing.T) {
 		})
 	}
 }
+
+func TestEquivalent(t *testing.T) {
+	digits := Binds{{"a", `\d+`}, {"b", `\w+`}}
+	base := MustParse(`x ${a} ${b}`, digits)
+	tests := []struct {
+		other *P
+		want  bool
+	}{
+		{base, true},
+		{MustParse(`x ${a} ${b}`, digits), true},
+		{MustParse(`x ${a} ${b}`, append(digits, Bind{"c", "ignored"})), true},
+		{MustParse(`x ${a}  ${b}`, digits), false},
+		{MustParse(`x ${b} ${a}`, digits), false},
+		{MustParse(`x ${a} ${b}`, Binds{{"a", `\d*`}, {"b", `\w+`}}), false},
+		{MustParse(`x ${a} ${b?}`, digits), false},
+		{base.Trim("a"), false},
+		{MustParse(`x ${a} ${b}`, digits, AnchorStart()), false},
+		{MustParse(`x ${a} ${b}`, digits, Alias("a", "c")), false},
+	}
+	for _, test := range tests {
+		if got := base.Equivalent(test.other); got != test.want {
+			t.Errorf("Equivalent(%q, %q): got %v, want %v", base, test.other, got, test.want)
+		}
+	}
+}
+
+func TestWordDiff(t *testing.T) {
+	tests := []struct {
+		a, b         string
+		onlyA, onlyB []string
+	}{
+		{"", "", nil, nil},
+		{"${a}", "${a}${a}", nil, nil},
+		{"${a} ${b}", "${b}", []string{"a"}, nil},
+		{"${b}", "${b} ${a}", nil, []string{"a"}},
+		{"${z} ${x} ${c}", "${y} ${c} ${a}", []string{"x", "z"}, []string{"a", "y"}},
+		{"${!x}${a}", "${a}", nil, nil},
+		{"${a}${!a}", "${!b}${b}", []string{"a"}, []string{"b"}},
+	}
+	for _, test := range tests {
+		a, b := MustParse(test.a, nil), MustParse(test.b, nil)
+		onlyA, onlyB := a.WordDiff(b)
+		if !reflect.DeepEqual(onlyA, test.onlyA) || !reflect.DeepEqual(onlyB, test.onlyB) {
+			t.Errorf("WordDiff(%q, %q): got %+q, %+q; want %+q, %+q",
+				test.a, test.b, onlyA, onlyB, test.onlyA, test.onlyB)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	if got := MustParse(`${a}-${b}`, Binds{{"a", `\d+`}, {"b", `\w+`}}).Lint(); got != nil {
+		t.Errorf("Lint clean: got %q, want nil", got)
+	}
+
+	p := MustParse(`${a}${b} ${c} ${d} ${a}`, Binds{
+		{"a", `\d+`}, {"b", `\w*`}, {"c", `x`}, {"c", `y`}, {"d", `z`},
+	}, Alias("d", "e"), Alias("e", "d"))
+	want := []string{
+		`word "a": directly followed by word "b"`,
+		`word "b": expression "\\w*" can match the empty string`,
+		`word "c": bound to conflicting expressions`,
+		`word "e": alias cycle`,
+		`word "d": alias cycle`,
+	}
+	if got := p.Lint(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint:\ngot  %q\nwant %q", got, want)
+	}
+	if got := MustParse(`${x}`, nil).Lint(); len(got) != 1 || !strings.Contains(got[0], "no expression") {
+		t.Errorf("Lint unbound: got %q, want no expression", got)
+	}
+
+	neg := MustParse(`a${!x}${!y}${!x}b${!z}`, Binds{{"y", `c*`}, {"z", `c`}})
+	want = []string{
+		`negative word "x": has no expression, so it rejects every match`,
+		`negative word "y": expression "c*" can match the empty string, so it rejects every match`,
+	}
+	if got := neg.Lint(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Lint negative:\ngot  %q\nwant %q", got, want)
+	}
+}
+
+func TestCheckAmbiguous(t *testing.T) {
+	tests := []struct {
+		pattern string
+		ok      bool
+	}{
+		{"", true},
+		{"${a}", true},
+		{"${a} ${b}", true},
+		{"${a}-${a}-${b}", true},
+		{"${a}${b}", false},
+		{"x ${a}${a} y", false},
+		{"${a}:${b}${c}", false},
+		{"${a}$$${b}", true},
+	}
+	for _, test := range tests {
+		err := MustParse(test.pattern, nil).CheckAmbiguous()
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("CheckAmbiguous %q: got %v, want ok=%v", test.pattern, err, test.ok)
+		}
+	}
+}
+
+func TestPrefixRegexp(t *testing.T) {
+	p := MustParse(`id=${id}; n=${n}`, Binds{{"id", `[a-z]+`}, {"n", `\d{2}`}})
+	re, err := p.PrefixRegexp()
+	if err != nil {
+		t.Fatalf("PrefixRegexp failed: %v", err)
+	}
+	const full = "id=ab; n=12"
+	for i := range len(full) + 1 {
+		if !re.MatchString(full[:i]) {
+			t.Errorf("PrefixRegexp %q: prefix %q did not match", re, full[:i])
+		}
+	}
+	for _, bad := range []string{"x", "id:", "id=1", "id=ab;n", "id=ab; n=x", "id=ab; n=123", full + " "} {
+		if re.MatchString(bad) {
+			t.Errorf("PrefixRegexp %q: %q matched unexpectedly", re, bad)
+		}
+	}
+
+	// A partial word captures the text seen so far.
+	m := re.FindStringSubmatch("id=abc; n=1")
+	if m == nil {
+		t.Fatal("PrefixRegexp: partial input did not match")
+	}
+	if got := m[re.SubexpIndex("n")]; got != "1" {
+		t.Errorf("PrefixRegexp partial n: got %q, want 1", got)
+	}
+	if got := m[re.SubexpIndex("id")]; got != "abc" {
+		t.Errorf("PrefixRegexp complete id: got %q, want abc", got)
+	}
+
+	// A word cut off before the end of the template is captured by the
+	// second group with its name.
+	q := MustParse(`${id}-${n}`, Binds{{"id", `\d{3}`}, {"n", `\d+`}})
+	qre, err := q.PrefixRegexp()
+	if err != nil {
+		t.Fatalf("PrefixRegexp failed: %v", err)
+	}
+	var groups []int
+	for i, name := range qre.SubexpNames() {
+		if name == "id" {
+			groups = append(groups, i)
+		}
+	}
+	if len(groups) != 2 {
+		t.Fatalf("PrefixRegexp: got %d groups for id, want 2", len(groups))
+	}
+	for _, test := range []struct {
+		input           string
+		complete, short string
+	}{
+		{"123-4", "123", ""},
+		{"12", "", "12"},
+	} {
+		m := qre.FindStringSubmatch(test.input)
+		if m == nil {
+			t.Errorf("PrefixRegexp %q: did not match", test.input)
+		} else if m[groups[0]] != test.complete || m[groups[1]] != test.short {
+			t.Errorf("PrefixRegexp %q: got id %q, %q; want %q, %q", test.input, m[groups[0]], m[groups[1]], test.complete, test.short)
+		}
+	}
+}
+
+func TestExportRegexp(t *testing.T) {
+	p := MustParse(`${a:b}=${c_d}.`, Binds{{"a:b", `\w+`}, {"c_d", `(x|y)+`}})
+	tests := []struct {
+		anchored bool
+		want     string
+	}{
+		{false, `(?P<a_3ab>[0-9A-Z_a-z]+)=(?P<c__d>[xy]+)\.`},
+		{true, `\A(?:(?P<a_3ab>[0-9A-Z_a-z]+)=(?P<c__d>[xy]+)\.)\z`},
+	}
+	for _, test := range tests {
+		got, err := p.ExportRegexp(test.anchored)
+		if err != nil {
+			t.Errorf("ExportRegexp(%v) failed: %v", test.anchored, err)
+		} else if got != test.want {
+			t.Errorf("ExportRegexp(%v): got %q, want %q", test.anchored, got, test.want)
+		}
+	}
+
+	// Words whose names are not valid group names can still be matched.
+	m, err := p.Match("key=xyx.")
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if want := (Binds{{"a:b", "key"}, {"c_d", "xyx"}}); !reflect.DeepEqual(m, want) {
+		t.Errorf("Match: got %+v, want %+v", m, want)
+	}
+
+	for _, word := range []string{"", "abc", "a:b", "_e_", "--F", "+gee", "#25", "h=18", "x/y"} {
+		if got, ok := WordName(groupName(word)); !ok || got != word {
+			t.Errorf("WordName(groupName(%q)): got %q, %v; want %q, true", word, got, ok, word)
+		}
+	}
+	for _, bad := range []string{"_", "a_3", "a_zz"} {
+		if got, ok := WordName(bad); ok {
+			t.Errorf("WordName(%q): got %q, wanted failure", bad, got)
+		}
+	}
+}
+
+func TestMinLen(t *testing.T) {
+	tests := []struct {
+		pattern string
+		binds   Binds
+		want    int
+	}{
+		{"", nil, 0},
+		{"alpha", nil, 5},
+		{"$${x}", nil, 4},
+		{"A#${num}", Binds{{"num", `\d+`}}, 3},
+		{"A#${num}", Binds{{"num", `\d*`}}, 2},
+		{"${a}-${a}", Binds{{"a", `[a-z]{3,5}`}}, 7},
+		{"${a}", Binds{{"a", `foo|ba(r|zz)`}}, 3},
+		{"${a}", Binds{{"a", `(?i)abc`}}, 3},
+		{"${a}", Binds{{"a", `ü+`}}, 2},
+		{"x${a}y", Binds{{"a", `^$`}}, 2},
+		{"x${a}y", Binds{{"a", `[bad`}}, 2},
+	}
+	for _, test := range tests {
+		p := MustParse(test.pattern, test.binds)
+		if got := p.MinLen(); got != test.want {
+			t.Errorf("MinLen %q %+v: got %d, want %d", test.pattern, test.binds, got, test.want)
+		}
+	}
+
+	// Verify that a too-short needle is rejected.
+	p := MustParse(`${a}:${b}`, Binds{{"a", `\w{4}`}, {"b", `\d+`}})
+	if m, err := p.Match("ab:1"); err != ErrNoMatch {
+		t.Errorf("Match: got %+v, %v; want %v", m, err, ErrNoMatch)
+	}
+}
+
+func BenchmarkSearch(b *testing.B) {
+	p := MustParse(`${key}=${value};`, Binds{{"key", `\w+`}, {"value", `[^;]*`}})
+	var buf strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&buf, "key%d=value%d; ", i, i)
+	}
+	needle := buf.String()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := p.Search(needle, func(start, end int, binds Binds) error {
+			return nil
+		}); err != nil {
+			b.Fatalf("Search failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkApply(b *testing.B) {
+	p := MustParse(`${key}=${value}; ${key} is ${value}`, nil)
+	binds := Binds{{"key", "alpha"}, {"value", "bravo"}}
+
+	b.Run("Apply", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := p.Apply(binds); err != nil {
+				b.Fatalf("Apply failed: %v", err)
+			}
+		}
+	})
+	b.Run("AppendApply", func(b *testing.B) {
+		var buf []byte
+		for i := 0; i < b.N; i++ {
+			var err error
+			buf, err = p.AppendApply(buf[:0], binds)
+			if err != nil {
+				b.Fatalf("AppendApply failed: %v", err)
+			}
+		}
+	})
+}
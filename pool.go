@@ -0,0 +1,84 @@
+package pattern
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A Pool is a cache of compiled patterns, keyed by template and bindings. A
+// Pool is safe for concurrent use by multiple goroutines, and so are the
+// patterns it returns, provided they are not modified.
+type Pool struct {
+	max int
+
+	mu    sync.Mutex
+	byKey map[string]*list.Element
+	order list.List // most recently used at the front
+}
+
+type poolEntry struct {
+	key string
+	p   *P
+}
+
+// NewPool constructs a new empty Pool that holds at most max patterns. When
+// the pool is full, the least recently used pattern is evicted to make room.
+// If max ≤ 0, the pool has no size limit.
+func NewPool(max int) *Pool { return &Pool{max: max, byKey: make(map[string]*list.Element)} }
+
+// Get returns a compiled pattern for template with the given bindings, as
+// constructed by Parse. If the pool already contains such a pattern, Get
+// returns it; otherwise Get parses and compiles a new one and adds it to the
+// pool. An error is reported if template is invalid or if any of its bound
+// expressions cannot be compiled.
+//
+// Bindings are compared by value and in order, so equivalent bindings given
+// in a different order produce a separate entry.
+func (pl *Pool) Get(template string, binds []Bind) (*P, error) {
+	key := poolKey(template, binds)
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	if elt, ok := pl.byKey[key]; ok {
+		pl.order.MoveToFront(elt)
+		return elt.Value.(*poolEntry).p, nil
+	}
+
+	p, err := Parse(template, binds)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compile all the cached state of p eagerly, since later lazy compilation
+	// would not be safe for concurrent use.
+	if err := p.Precompile(); err != nil {
+		return nil, err
+	}
+
+	pl.byKey[key] = pl.order.PushFront(&poolEntry{key: key, p: p})
+	if pl.max > 0 && pl.order.Len() > pl.max {
+		last := pl.order.Back()
+		pl.order.Remove(last)
+		delete(pl.byKey, last.Value.(*poolEntry).key)
+	}
+	return p, nil
+}
+
+// Len reports the number of patterns currently held by pl.
+func (pl *Pool) Len() int {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.order.Len()
+}
+
+// poolKey returns a string that uniquely identifies template and binds.
+func poolKey(template string, binds []Bind) string {
+	var buf strings.Builder
+	buf.WriteString(strconv.Quote(template))
+	for _, b := range binds {
+		buf.WriteString(strconv.Quote(b.Name))
+		buf.WriteString(strconv.Quote(b.Expr))
+	}
+	return buf.String()
+}
@@ -0,0 +1,60 @@
+package pattern
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	pl := NewPool(2)
+	binds := Binds{{"n", `\d+`}}
+
+	a, err := pl.Get(`a${n}`, binds)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if again, err := pl.Get(`a${n}`, binds); err != nil || again != a {
+		t.Errorf("Get again: got %p, %v; want %p, nil", again, err, a)
+	}
+	if other, err := pl.Get(`a${n}`, Binds{{"n", `\w+`}}); err != nil || other == a {
+		t.Errorf("Get other binds: got %p, %v; want new pattern", other, err)
+	}
+
+	// Adding a third pattern evicts the least recently used one, a.
+	if _, err := pl.Get(`b${n}`, binds); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if n := pl.Len(); n != 2 {
+		t.Errorf("Len: got %d, want 2", n)
+	}
+	if again, err := pl.Get(`a${n}`, binds); err != nil || again == a {
+		t.Errorf("Get after eviction: got %p, %v; want new pattern", again, err)
+	}
+
+	if p, err := pl.Get(`${`, nil); err == nil {
+		t.Errorf("Get invalid template: got %v, wanted error", p)
+	}
+	if p, err := pl.Get(`${x}`, Binds{{"x", "[bad"}}); err == nil {
+		t.Errorf("Get invalid binding: got %v, wanted error", p)
+	}
+
+	// Patterns from the pool may be used concurrently.
+	p, err := pl.Get(`${k}=${v}`, Binds{{"k", `\w+`}, {"v", `\d+`}})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := p.Match("x=1"); err != nil {
+				t.Errorf("Match failed: %v", err)
+			}
+			if err := p.SearchLines("y=2\nz=3", func(int, int, Binds) error { return nil }); err != nil {
+				t.Errorf("SearchLines failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
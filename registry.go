@@ -0,0 +1,35 @@
+package pattern
+
+import "sync"
+
+var registry struct {
+	sync.Mutex
+	byName map[string]*P
+}
+
+// Register adds p to the global registry of patterns under the given name, so
+// that it may later be retrieved with Lookup. Register panics if name is
+// already registered or if p == nil.
+func Register(name string, p *P) {
+	if p == nil {
+		panic("pattern: register of nil pattern")
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.byName[name]; ok {
+		panic("pattern: duplicate registration of " + name)
+	}
+	if registry.byName == nil {
+		registry.byName = make(map[string]*P)
+	}
+	registry.byName[name] = p
+}
+
+// Lookup reports whether a pattern is registered under the given name, and if
+// so returns it.
+func Lookup(name string) (*P, bool) {
+	registry.Lock()
+	defer registry.Unlock()
+	p, ok := registry.byName[name]
+	return p, ok
+}
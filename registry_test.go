@@ -0,0 +1,26 @@
+package pattern
+
+import "testing"
+
+func TestRegistry(t *testing.T) {
+	p := MustParse(`${x}+${y}`, nil)
+	Register("test/sum", p)
+
+	if got, ok := Lookup("test/sum"); !ok || got != p {
+		t.Errorf("Lookup(test/sum): got %v, %v; want %v, true", got, ok, p)
+	}
+	if got, ok := Lookup("test/nonesuch"); ok {
+		t.Errorf("Lookup(test/nonesuch): got %v, wanted not found", got)
+	}
+
+	t.Run("Duplicate", func(t *testing.T) {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Error("Register of duplicate name did not panic")
+			} else {
+				t.Logf("Register correctly panicked: %v", x)
+			}
+		}()
+		Register("test/sum", MustParse(`${x}-${y}`, nil))
+	})
+}
@@ -0,0 +1,64 @@
+package pattern
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Scan reads r line by line, matches each line against p, as Match, and
+// calls f with the bindings from each line that matches. Line endings are not
+// included in the text matched. If f reports an error, the scan ends. If the
+// error is ErrStopSearch, Scan returns nil. Otherwise Scan returns the error
+// from f.
+//
+// By default, Scan reports an error wrapping ErrNoMatch for the first line
+// that does not match p. Use the SkipMismatch or StopAtMismatch options to
+// change this behavior.
+func (p *P) Scan(r io.Reader, f func(binds Binds) error, opts ...ScanOption) error {
+	var o scanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		binds, err := p.Match(s.Text())
+		if err == ErrNoMatch {
+			switch o.mismatch {
+			case mismatchSkip:
+				continue
+			case mismatchStop:
+				return nil
+			}
+			return fmt.Errorf("line %d: %w", n, err)
+		} else if err != nil {
+			return err
+		}
+		if err := f(binds); err == ErrStopSearch {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// A ScanOption modifies the behavior of the Scan method.
+type ScanOption func(*scanOptions)
+
+type scanOptions struct {
+	mismatch int // how to handle lines that do not match
+}
+
+const (
+	mismatchError = iota
+	mismatchSkip
+	mismatchStop
+)
+
+// SkipMismatch is an option that makes Scan skip lines that do not match.
+func SkipMismatch() ScanOption { return func(o *scanOptions) { o.mismatch = mismatchSkip } }
+
+// StopAtMismatch is an option that makes Scan stop without error at the first
+// line that does not match.
+func StopAtMismatch() ScanOption { return func(o *scanOptions) { o.mismatch = mismatchStop } }
@@ -0,0 +1,47 @@
+package pattern
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	p := MustParse(`${key}: ${value}`, Binds{{"key", `\w+`}, {"value", `.*`}})
+	const input = "a: 1\nb: 2\n-- bogus --\nc: 3\n"
+
+	tests := []struct {
+		desc string
+		opts []ScanOption
+		want []string
+		ok   bool
+	}{
+		{"Default", nil, []string{"a", "b"}, false},
+		{"Skip", []ScanOption{SkipMismatch()}, []string{"a", "b", "c"}, true},
+		{"Stop", []ScanOption{StopAtMismatch()}, []string{"a", "b"}, true},
+	}
+	for _, test := range tests {
+		var got []string
+		err := p.Scan(strings.NewReader(input), func(binds Binds) error {
+			got = append(got, binds.First("key"))
+			return nil
+		}, test.opts...)
+		if test.ok && err != nil {
+			t.Errorf("Scan %s failed: %v", test.desc, err)
+		} else if !test.ok && !errors.Is(err, ErrNoMatch) {
+			t.Errorf("Scan %s: got error %v, want %v", test.desc, err, ErrNoMatch)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Scan %s: got %+q, want %+q", test.desc, got, test.want)
+		}
+	}
+
+	var n int
+	if err := p.Scan(strings.NewReader(input), func(Binds) error {
+		n++
+		return ErrStopSearch
+	}); err != nil || n != 1 {
+		t.Errorf("Scan with stop: got %d, %v; want 1, nil", n, err)
+	}
+}
@@ -3,7 +3,11 @@
 package transform
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 
 	"github.com/creachadair/pattern"
@@ -14,22 +18,133 @@ import (
 // applies the resulting bindings to R.
 type T struct {
 	lhs, rhs *pattern.P
+
+	// For a transformation constructed by NewMulti, the right patterns and
+	// the function that chooses among them. Otherwise, alts == nil.
+	alts   []*pattern.P
+	choose func(pattern.Binds) int
 }
 
 // New constructs a new transformation from the template strings lhs and rhs,
-// and the bindings shared by both templates.
+// and the bindings shared by both templates. If either template is malformed,
+// New reports a *SyntaxError. If rhs refers to a pattern word that does not
+// occur in lhs, New reports an *UnknownWordError.
 func New(lhs, rhs string, binds pattern.Binds) (*T, error) {
 	lp, err := pattern.Parse(lhs, binds)
 	if err != nil {
-		return nil, fmt.Errorf("parsing %q: %v", lhs, err)
+		return nil, &SyntaxError{Template: lhs, Err: err}
 	}
-	rp, err := lp.Derive(rhs)
+	rp, err := derive(lp, rhs)
 	if err != nil {
 		return nil, err
 	}
 	return &T{lhs: lp, rhs: rp}, nil
 }
 
+// NewMulti constructs a new transformation from the template string lhs and
+// several alternative right templates, with the bindings shared by all the
+// templates. Applying the result matches lhs against the needle, and applies
+// the bindings to rhs[choose(binds)], where binds are the bindings from the
+// match. It is an error if rhs is empty or choose == nil, and errors in the
+// templates are reported as by New.
+//
+// The methods of T that do not apply the transformation to a needle, such as
+// String, Transform, Reverse, and Reversible, use rhs[0] as the right
+// pattern.
+func NewMulti(lhs string, rhs []string, binds pattern.Binds, choose func(binds pattern.Binds) int) (*T, error) {
+	if len(rhs) == 0 {
+		return nil, errors.New("no right templates")
+	} else if choose == nil {
+		return nil, errors.New("no choice function")
+	}
+	lp, err := pattern.Parse(lhs, binds)
+	if err != nil {
+		return nil, &SyntaxError{Template: lhs, Err: err}
+	}
+	t := &T{lhs: lp, choose: choose}
+	for _, s := range rhs {
+		rp, err := derive(lp, s)
+		if err != nil {
+			return nil, err
+		}
+		t.alts = append(t.alts, rp)
+	}
+	t.rhs = t.alts[0]
+	return t, nil
+}
+
+// ParseTransform constructs a new transformation from a spec of the form
+// "lhs => rhs", as New(lhs, rhs, binds). Whitespace surrounding each template
+// is removed. A literal "=>" within either template is escaped as `\=>`.
+// It is an error if spec does not contain exactly one unescaped "=>".
+func ParseTransform(spec string, binds pattern.Binds) (*T, error) {
+	var sides []string
+	var cur strings.Builder
+	for i := 0; i < len(spec); i++ {
+		if strings.HasPrefix(spec[i:], `\=>`) {
+			cur.WriteString("=>")
+			i += 2
+		} else if strings.HasPrefix(spec[i:], "=>") {
+			sides = append(sides, cur.String())
+			cur.Reset()
+			i++
+		} else {
+			cur.WriteByte(spec[i])
+		}
+	}
+	sides = append(sides, cur.String())
+	if len(sides) != 2 {
+		return nil, fmt.Errorf("spec %q has %d delimiters, want 1", spec, len(sides)-1)
+	}
+	return New(strings.TrimSpace(sides[0]), strings.TrimSpace(sides[1]), binds)
+}
+
+// derive constructs the right pattern for rhs from the left pattern lp,
+// reporting errors as described by New.
+func derive(lp *pattern.P, rhs string) (*pattern.P, error) {
+	check, err := pattern.Parse(rhs, nil)
+	if err != nil {
+		return nil, &SyntaxError{Template: rhs, Err: err}
+	}
+	for _, b := range check.Binds() {
+		if _, ok := lp.Expr(b.Name); !ok {
+			return nil, &UnknownWordError{Word: b.Name}
+		}
+	}
+	return lp.Derive(rhs)
+}
+
+// target returns the right pattern of t to which binds should be applied.
+func (t *T) target(binds pattern.Binds) (*pattern.P, error) {
+	if t.alts == nil {
+		return t.rhs, nil
+	}
+	i := t.choose(binds)
+	if i < 0 || i >= len(t.alts) {
+		return nil, fmt.Errorf("choice %d out of range for %d templates", i, len(t.alts))
+	}
+	return t.alts[i], nil
+}
+
+// A SyntaxError is reported by New when a template string is malformed.
+type SyntaxError struct {
+	Template string // the malformed template
+	Err      error  // the error from parsing the template
+}
+
+func (e *SyntaxError) Error() string { return fmt.Sprintf("parsing %q: %v", e.Template, e.Err) }
+
+// Unwrap supports error wrapping.
+func (e *SyntaxError) Unwrap() error { return e.Err }
+
+// An UnknownWordError is reported by New when the right template refers to a
+// pattern word that does not occur in the left template.
+type UnknownWordError struct {
+	Word string // the unknown pattern word
+}
+
+func (e *UnknownWordError) Error() string { return fmt.Sprintf("unknown pattern word %q", e.Word) }
+
 // Must acts as New, but panics if an error is reported. This function exists
 // to support static initialization.
 func Must(lhs, rhs string, binds pattern.Binds) *T {
@@ -40,14 +155,102 @@ func Must(lhs, rhs string, binds pattern.Binds) *T {
 	return t
 }
 
+// String returns a human-readable representation of t, giving the templates
+// of its left and right patterns.
+func (t *T) String() string { return fmt.Sprintf("%q => %q", t.lhs.String(), t.rhs.String()) }
+
+// Patterns returns the left and right patterns of t. For a transformation
+// constructed by NewMulti, rhs is the first of the right patterns. The results
+// are shared with t, and the caller must not modify them.
+func (t *T) Patterns() (lhs, rhs *pattern.P) { return t.lhs, t.rhs }
+
 // Apply matches needle against the left pattern of t, and if it matches
-// applies the result to the right pattern of t.
+// applies the result to the right pattern of t. A pattern word that matched
+// an empty string, or did not participate in the match, is applied as an empty
+// string.
 func (t *T) Apply(needle string) (string, error) {
+	out, _, err := t.ApplyBindings(needle)
+	return out, err
+}
+
+// ApplyBindings behaves like Apply, but also returns the bindings captured by
+// matching needle against the left pattern of t. If the match fails, the
+// bindings are nil; if the match succeeds but the result cannot be applied,
+// the bindings are returned along with the error.
+func (t *T) ApplyBindings(needle string) (string, pattern.Binds, error) {
+	ms, err := t.lhs.Match(needle)
+	if err != nil {
+		return "", nil, err
+	}
+	out, err := t.apply(t.fill(ms))
+	return out, ms, err
+}
+
+// apply applies binds to the right pattern of t chosen for them.
+func (t *T) apply(binds pattern.Binds) (string, error) {
+	rp, err := t.target(binds)
+	if err != nil {
+		return "", err
+	}
+	return rp.Apply(binds)
+}
+
+// Transform converts binds, which give values for the pattern words of the
+// left pattern of t, into bindings for the right pattern of t, in the order
+// of occurrence in its template. Values are assigned as by Apply, so that the
+// result applied to the right pattern gives the same string as applying binds
+// directly. It is an error if binds has no value for a word of the right
+// pattern.
+func (t *T) Transform(binds pattern.Binds) (pattern.Binds, error) {
+	sub := make(map[string][]string)
+	for _, b := range binds {
+		sub[b.Name] = append(sub[b.Name], b.Expr)
+	}
+	var out pattern.Binds
+	for _, b := range t.rhs.Binds() {
+		s := sub[b.Name]
+		if len(s) == 0 {
+			return nil, fmt.Errorf("missing binding for %q", b.Name)
+		}
+		out = append(out, pattern.Bind{Name: b.Name, Expr: s[0]})
+		if len(s) > 1 {
+			sub[b.Name] = s[1:]
+		}
+	}
+	return out, nil
+}
+
+// ApplyMapFunc behaves like Apply, but passes the name and value of each
+// binding captured from the left pattern of t through f, and applies the
+// results to the right pattern of t. Since f may change the values, the
+// result may not be reversible even if t is Reversible.
+func (t *T) ApplyMapFunc(needle string, f func(name, value string) string) (string, error) {
 	ms, err := t.lhs.Match(needle)
 	if err != nil {
 		return "", err
 	}
-	return t.rhs.Apply(ms)
+	ms = t.fill(ms)
+	for i, m := range ms {
+		ms[i].Expr = f(m.Name, m.Expr)
+	}
+	return t.apply(ms)
+}
+
+// ApplyFirst applies each of ts to needle in order, and returns the result
+// from the first whose left pattern matches, along with its index in ts. If
+// none of ts matches needle, ApplyFirst returns -1, pattern.ErrNoMatch. Any
+// other error ends the scan and is returned with the index of its source.
+func ApplyFirst(ts []*T, needle string) (string, int, error) {
+	for i, t := range ts {
+		out, err := t.Apply(needle)
+		if err == pattern.ErrNoMatch {
+			continue
+		} else if err != nil {
+			return "", i, err
+		}
+		return out, i, nil
+	}
+	return "", -1, pattern.ErrNoMatch
 }
 
 // Search scans needle for all non-overlapping matches of the left pattern of
@@ -58,7 +261,7 @@ func (t *T) Apply(needle string) (string, error) {
 // the error from f.
 func (t *T) Search(needle string, f func(start, end int, match string) error) error {
 	return t.lhs.Search(needle, func(start, end int, binds pattern.Binds) error {
-		out, err := t.rhs.Apply(binds)
+		out, err := t.apply(t.fill(binds))
 		if err != nil {
 			return err
 		}
@@ -67,25 +270,236 @@ func (t *T) Search(needle string, f func(start, end int, match string) error) er
 }
 
 // Replace replaces all non-overlapping matches of the left pattern of t with
-// the results of applying the right pattern of t.
+// the results of applying the right pattern of t. Text of needle outside the
+// matches, including any after the last match, is copied unchanged.
 func (t *T) Replace(needle string) (string, error) {
+	var out strings.Builder
+	if err := t.ReplaceTo(&out, needle); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// ReplaceWithGap behaves like Replace, but passes each replacement through
+// f along with gap, the text of needle between the end of the previous match
+// (or the start of needle) and the start of this match. The gap is copied to
+// the output as usual, followed by the string returned by f in place of the
+// replacement. If f reports an error, ReplaceWithGap returns that error. If
+// f == nil, ReplaceWithGap behaves like Replace.
+func (t *T) ReplaceWithGap(needle string, f func(gap, match string) (string, error)) (string, error) {
+	var out strings.Builder
+	cur := 0
+	if err := t.Search(needle, func(start, end int, match string) error {
+		gap := needle[cur:start]
+		out.WriteString(gap)
+		if f != nil {
+			s, err := f(gap, match)
+			if err != nil {
+				return err
+			}
+			match = s
+		}
+		out.WriteString(match)
+		cur = end
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	out.WriteString(needle[cur:])
+	return out.String(), nil
+}
+
+// ApplyFixed calls Replace repeatedly, starting with needle and continuing
+// with each result in turn, until the result no longer changes. It reports an
+// error if the result is still changing after maxIters calls to Replace.
+// Replace is always called at least once, so if needle is already a fixed
+// point ApplyFixed returns it even when maxIters <= 0.
+func (t *T) ApplyFixed(needle string, maxIters int) (string, error) {
+	for i := 1; ; i++ {
+		next, err := t.Replace(needle)
+		if err != nil {
+			return "", err
+		} else if next == needle {
+			return next, nil
+		} else if i >= maxIters {
+			return "", fmt.Errorf("no fixed point after %d iterations", maxIters)
+		}
+		needle = next
+	}
+}
+
+// An Edit records a single replacement made by Rewrite.
+type Edit struct {
+	Start, End int    // offsets of the replaced text in the input
+	Old, New   string // the replaced text and its replacement
+}
+
+// Rewrite behaves like Replace, but also reports the replacements that were
+// made, in order of occurrence.
+func (t *T) Rewrite(needle string) (result string, edits []Edit, err error) {
 	var out strings.Builder
 	cur := 0
 	if err := t.Search(needle, func(start, end int, match string) error {
 		out.WriteString(needle[cur:start])
 		out.WriteString(match)
+		edits = append(edits, Edit{Start: start, End: end, Old: needle[start:end], New: match})
+		cur = end
+		return nil
+	}); err != nil {
+		return "", nil, err
+	}
+	out.WriteString(needle[cur:])
+	return out.String(), edits, nil
+}
+
+// ReplaceAllFunc replaces all non-overlapping matches of the left pattern of
+// t with the result of calling f on the matched text, in the manner of the
+// ReplaceAllStringFunc method of regexp.Regexp. Unlike Replace, the right
+// pattern of t is not used.
+func (t *T) ReplaceAllFunc(needle string, f func(match string) string) (string, error) {
+	var out strings.Builder
+	cur := 0
+	if err := t.lhs.Search(needle, func(start, end int, _ pattern.Binds) error {
+		out.WriteString(needle[cur:start])
+		out.WriteString(f(needle[start:end]))
 		cur = end
 		return nil
 	}); err != nil {
 		return "", err
 	}
+	out.WriteString(needle[cur:])
 	return out.String(), nil
 }
 
+// ReplaceTo behaves like Replace, but writes the result to w incrementally as
+// each match is found, rather than accumulating it in memory.  If writing to w
+// fails, ReplaceTo returns that error.
+func (t *T) ReplaceTo(w io.Writer, needle string) error { return t.replaceTo(w, needle, nil) }
+
+// Stream reads r a line at a time, replaces the matches of t in each line as
+// ReplaceTo, and writes the result to w. Each line includes its trailing
+// newline, if any, so the line structure of the input is preserved. Because
+// each line is processed separately, a match cannot span lines; a pattern that
+// must match across a newline will not find those matches. An error reading
+// r or writing w is reported as soon as it occurs.
+func (t *T) Stream(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			if werr := t.ReplaceTo(w, line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// ReplaceSep behaves like Replace, but passes each run of text not covered by
+// a match through sep before adding it to the result. This includes the runs
+// before the first match and after the last, and the (possibly empty) runs
+// between adjacent matches. ReplaceSep will panic if sep == nil.
+func (t *T) ReplaceSep(needle string, sep func(between string) string) (string, error) {
+	if sep == nil {
+		panic("transform: nil separator function")
+	}
+	var out strings.Builder
+	if err := t.replaceTo(&out, needle, sep); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// replaceTo implements ReplaceTo and ReplaceSep. If sep != nil, it is applied
+// to each run of unmatched text.
+func (t *T) replaceTo(w io.Writer, needle string, sep func(string) string) error {
+	between := func(s string) string {
+		if sep != nil {
+			return sep(s)
+		}
+		return s
+	}
+	cur := 0
+	if err := t.Search(needle, func(start, end int, match string) error {
+		if _, err := io.WriteString(w, between(needle[cur:start])); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, match); err != nil {
+			return err
+		}
+		cur = end
+		return nil
+	}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, between(needle[cur:]))
+	return err
+}
+
+// fill returns binds extended with an empty binding for each pattern word of
+// the left pattern of t that does not occur in binds. This allows a word that
+// did not participate in a match to be applied as an empty string.
+func (t *T) fill(binds pattern.Binds) pattern.Binds {
+	for _, b := range t.lhs.Binds() {
+		if !binds.Has(b.Name) {
+			binds = append(binds, pattern.Bind{Name: b.Name})
+		}
+	}
+	return binds
+}
+
 // Reverse returns the reverse of t, with its left and right templates
 // exchanged.
 func (t *T) Reverse() *T { return &T{lhs: t.rhs, rhs: t.lhs} }
 
+// ErrNotReversible is reported by SafeReverse for a transformation that is
+// not Reversible.
+var ErrNotReversible = errors.New("transformation is not reversible")
+
+// SafeReverse returns the reverse of t, as Reverse, if t is Reversible.
+// Otherwise it reports an error wrapping ErrNotReversible that describes a
+// pattern word whose occurrences differ between the templates of t.
+func (t *T) SafeReverse() (*T, error) {
+	if t.Reversible() {
+		return t.Reverse(), nil
+	}
+	count := func(binds pattern.Binds) map[string]int {
+		n := make(map[string]int)
+		for _, b := range binds {
+			n[b.Name]++
+		}
+		return n
+	}
+	nl, nr := count(t.lhs.Binds()), count(t.rhs.Binds())
+	var names []string
+	for name := range nl {
+		names = append(names, name)
+	}
+	for name := range nr {
+		if _, ok := nl[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if nl[name] != nr[name] {
+			return nil, fmt.Errorf("%w: word %q occurs %d times on the left and %d on the right",
+				ErrNotReversible, name, nl[name], nr[name])
+		}
+	}
+	return nil, ErrNotReversible // not reached
+}
+
+// Pair returns functions that apply t and its reverse, respectively. The
+// reverse function is only meaningful if t is Reversible.
+func (t *T) Pair() (forward, reverse func(string) (string, error)) {
+	return t.Apply, t.Reverse().Apply
+}
+
 // Reversible reports whether the bindings of t are mutually saturating,
 // meaning that each contains at least as many values for each binding as the
 // other requires. If this is false, it means applying the transformation
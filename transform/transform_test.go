@@ -1,8 +1,11 @@
 package transform
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/creachadair/pattern"
 )
@@ -154,11 +157,129 @@ func TestNewErrors(t *testing.T) {
 		}
 	}
 	const bogus = "${"
-	if tut, err := New(bogus, "OK", nil); err == nil {
-		t.Errorf("New(%q, OK, _): got %+v, wanted error", bogus, tut)
+	var serr *SyntaxError
+	if tut, err := New(bogus, "OK", nil); !errors.As(err, &serr) || serr.Template != bogus {
+		t.Errorf("New(%q, OK, _): got %+v, %v; wanted syntax error", bogus, tut, err)
 	}
-	if tut, err := New("OK", bogus, nil); err == nil {
-		t.Errorf("New(OK, %q, _): got %+v, wanted error", bogus, tut)
+	if tut, err := New("OK", bogus, nil); !errors.As(err, &serr) || serr.Template != bogus {
+		t.Errorf("New(OK, %q, _): got %+v, %v; wanted syntax error", bogus, tut, err)
+	} else if !errors.Is(err, pattern.ErrIncompleteWord) {
+		t.Errorf("New(OK, %q, _): got %v, wanted %v", bogus, err, pattern.ErrIncompleteWord)
+	}
+	var uerr *UnknownWordError
+	if tut, err := New("${a}", "${a} ${b}", nil); !errors.As(err, &uerr) || uerr.Word != "b" {
+		t.Errorf("New(${a}, ${a} ${b}, _): got %+v, %v; wanted unknown word b", tut, err)
+	}
+}
+
+func TestNewMulti(t *testing.T) {
+	tut, err := NewMulti("${n} ${thing}", []string{
+		"${n} ${thing}",
+		"${n} ${thing}s",
+	}, pattern.Binds{
+		{Name: "n", Expr: `\d+`}, {Name: "thing", Expr: `\w+`},
+	}, func(binds pattern.Binds) int {
+		if binds.First("n") == "1" {
+			return 0
+		}
+		return 1
+	})
+	if err != nil {
+		t.Fatalf("NewMulti failed: %v", err)
+	}
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"1 cat", "1 cat"},
+		{"3 cat", "3 cats"},
+		{"0 dog", "0 dogs"},
+	} {
+		if got, err := tut.Apply(test.input); err != nil || got != test.want {
+			t.Errorf("Apply(%q): got %q, %v; want %q", test.input, got, err, test.want)
+		}
+	}
+	if got, err := tut.Replace("1 cat, 2 dog"); err != nil || got != "1 cat, 2 dogs" {
+		t.Errorf("Replace: got %q, %v; want %q", got, err, "1 cat, 2 dogs")
+	}
+
+	choose := func(pattern.Binds) int { return 0 }
+	var uerr *UnknownWordError
+	if _, err := NewMulti("${a}", []string{"${a}", "${b}"}, nil, choose); !errors.As(err, &uerr) {
+		t.Errorf("NewMulti unknown word: got %v, want *UnknownWordError", err)
+	}
+	if _, err := NewMulti("${a}", nil, nil, choose); err == nil {
+		t.Error("NewMulti no templates: got nil, want error")
+	}
+	bad, err := NewMulti("${a}", []string{"${a}"}, nil, func(pattern.Binds) int { return 5 })
+	if err != nil {
+		t.Fatalf("NewMulti failed: %v", err)
+	}
+	if got, err := bad.Apply(""); err == nil {
+		t.Errorf("Apply with bad choice: got %q, want error", got)
+	}
+}
+
+func TestSafeReverse(t *testing.T) {
+	rev := Must("${a}-${b}", "${b}+${a}", pattern.Binds{
+		{Name: "a", Expr: `\d+`}, {Name: "b", Expr: `\d+`},
+	})
+	r, err := rev.SafeReverse()
+	if err != nil {
+		t.Fatalf("SafeReverse failed: %v", err)
+	}
+	if got, err := r.Apply("2+1"); err != nil || got != "1-2" {
+		t.Errorf("SafeReverse Apply: got %q, %v; want 1-2", got, err)
+	}
+
+	lossy := Must("${a}-${b}", "${a}", nil)
+	if r, err := lossy.SafeReverse(); !errors.Is(err, ErrNotReversible) {
+		t.Errorf("SafeReverse lossy: got %v, %v; want %v", r, err, ErrNotReversible)
+	} else if !strings.Contains(err.Error(), `"b" occurs 1 times on the left and 0 on the right`) {
+		t.Errorf("SafeReverse lossy: error %q lacks detail", err)
+	}
+}
+
+func TestParseTransform(t *testing.T) {
+	binds := pattern.Binds{{Name: "a", Expr: `\w+`}, {Name: "b", Expr: `\w+`}}
+	tests := []struct {
+		spec, input, want string
+	}{
+		{"${a}-${b} => ${b}+${a}", "x-y", "y+x"},
+		{"${a}-${b}=>${b}+${a}", "x-y", "y+x"},
+		{`${a}\=>${b} => ${b}<=${a}`, "x=>y", "y<=x"},
+		{`${a} => ${a}\=>`, "x", "x=>"},
+	}
+	for _, test := range tests {
+		tr, err := ParseTransform(test.spec, binds)
+		if err != nil {
+			t.Errorf("ParseTransform(%q) failed: %v", test.spec, err)
+			continue
+		}
+		if got, err := tr.Apply(test.input); err != nil || got != test.want {
+			t.Errorf("ParseTransform(%q) Apply %q: got %q, %v; want %q", test.spec, test.input, got, err, test.want)
+		}
+	}
+
+	for _, bad := range []string{"", "${a}", `${a} \=> ${a}`, "${a} => ${a} => ${a}", "${a => ${a}"} {
+		if tr, err := ParseTransform(bad, binds); err == nil {
+			t.Errorf("ParseTransform(%q): got %v, wanted error", bad, tr)
+		}
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tr := Must("${a}-${b}", "${b}+${a}", pattern.Binds{
+		{Name: "a", Expr: `\d+`}, {Name: "b", Expr: `\w+`},
+	})
+	lhs, rhs := tr.Patterns()
+	if got, want := lhs.String(), "${a}-${b}"; got != want {
+		t.Errorf("Patterns lhs: got %q, want %q", got, want)
+	}
+	if got, want := rhs.String(), "${b}+${a}"; got != want {
+		t.Errorf("Patterns rhs: got %q, want %q", got, want)
+	}
+	if m, err := lhs.Match("12-x"); err != nil || m.First("a") != "12" {
+		t.Errorf("Patterns lhs Match: got %+v, %v; want a=12", m, err)
 	}
 }
 
@@ -211,6 +332,325 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestReplaceTrailingText(t *testing.T) {
+	// Text after the last match is kept.
+	tut := Must("<${x}>", "[${x}]", pattern.Binds{{Name: "x", Expr: `\w+`}})
+	for _, test := range []struct {
+		input, want string
+	}{
+		{"<a> tail", "[a] tail"},
+		{"<a><b>!", "[a][b]!"},
+		{"no match", "no match"},
+	} {
+		if got, err := tut.Replace(test.input); err != nil || got != test.want {
+			t.Errorf("Replace %q: got %q, %v; want %q", test.input, got, err, test.want)
+		}
+	}
+}
+
+func TestReplaceTo(t *testing.T) {
+	tut := Must("${a}+${b}", "${b}+${a}", pattern.Binds{
+		{Name: "a", Expr: `\d+`}, {Name: "b", Expr: `\d+`},
+	})
+	const input = "x 1+2, y 30+4."
+	const want = "x 2+1, y 4+30."
+
+	var buf strings.Builder
+	if err := tut.ReplaceTo(&buf, input); err != nil {
+		t.Errorf("ReplaceTo %q failed: %v", input, err)
+	} else if got := buf.String(); got != want {
+		t.Errorf("ReplaceTo %q: got %q, want %q", input, got, want)
+	}
+}
+
+func TestReplaceWithGap(t *testing.T) {
+	tut := Must("- ${item}\n", "* ${item}\n", pattern.Binds{{Name: "item", Expr: `[^\n]+`}})
+	const input = "list:\n  - a\n    - b\n"
+
+	// Indent each replacement to match the indentation preceding it.
+	got, err := tut.ReplaceWithGap(input, func(gap, match string) (string, error) {
+		indent := gap[strings.LastIndex(gap, "\n")+1:]
+		return match + indent + "  (" + strings.TrimSpace(match) + ")\n", nil
+	})
+	if err != nil {
+		t.Fatalf("ReplaceWithGap failed: %v", err)
+	}
+	const want = "list:\n  * a\n    (* a)\n    * b\n      (* b)\n"
+	if got != want {
+		t.Errorf("ReplaceWithGap: got %q, want %q", got, want)
+	}
+
+	if got, err := tut.ReplaceWithGap(input, nil); err != nil {
+		t.Errorf("ReplaceWithGap nil failed: %v", err)
+	} else if want, _ := tut.Replace(input); got != want {
+		t.Errorf("ReplaceWithGap nil: got %q, want %q", got, want)
+	}
+	if got, err := tut.ReplaceWithGap(input, func(string, string) (string, error) {
+		return "", errors.New("bad")
+	}); err == nil {
+		t.Errorf("ReplaceWithGap: got %q, want error", got)
+	}
+}
+
+func TestStream(t *testing.T) {
+	tut := Must("${k}=${v}", "${v}:${k}", pattern.Binds{
+		{Name: "k", Expr: `\w+`}, {Name: "v", Expr: `\w+`},
+	})
+	const input = "a=1 b=2\nno match here\n\nc=3"
+	var out strings.Builder
+	if err := tut.Stream(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if got, want := out.String(), "1:a 2:b\nno match here\n\n3:c"; got != want {
+		t.Errorf("Stream: got %q, want %q", got, want)
+	}
+
+	rerr := errors.New("read failed")
+	if err := tut.Stream(iotest.ErrReader(rerr), &out); err != rerr {
+		t.Errorf("Stream read error: got %v, want %v", err, rerr)
+	}
+	if err := tut.Stream(strings.NewReader(input), failWriter{}); err == nil {
+		t.Error("Stream write error: got nil, want error")
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
+
+func TestApplyFixed(t *testing.T) {
+	tut := Must("(${x})", "${x}", pattern.Binds{{Name: "x", Expr: `[^()]*`}})
+	for _, test := range []struct {
+		input string
+		iters int
+		want  string
+	}{
+		{"", 1, ""},
+		{"a", 1, "a"},
+		{"a", 0, "a"},
+		{"((a)) + (b)", 3, "a + b"},
+		{"(((a)))", 4, "a"},
+	} {
+		got, err := tut.ApplyFixed(test.input, test.iters)
+		if err != nil {
+			t.Errorf("ApplyFixed(%q, %d) failed: %v", test.input, test.iters, err)
+		} else if got != test.want {
+			t.Errorf("ApplyFixed(%q, %d): got %q, want %q", test.input, test.iters, got, test.want)
+		}
+	}
+	if got, err := tut.ApplyFixed("(((a)))", 3); err == nil {
+		t.Errorf("ApplyFixed: got %q, wanted error", got)
+	}
+	if got, err := tut.ApplyFixed("(a)", 0); err == nil {
+		t.Errorf("ApplyFixed: got %q, wanted error", got)
+	}
+
+	grow := Must("${x}", "${x}!", pattern.Binds{{Name: "x", Expr: `a+`}})
+	if got, err := grow.ApplyFixed("a", 10); err == nil {
+		t.Errorf("ApplyFixed: got %q, wanted error", got)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	tut := Must("${a}+${b}", "${b}+${a}", pattern.Binds{
+		{Name: "a", Expr: `\d+`}, {Name: "b", Expr: `\d+`},
+	})
+	const input = "x 1+2, y 30+4."
+	got, edits, err := tut.Rewrite(input)
+	if err != nil {
+		t.Fatalf("Rewrite %q failed: %v", input, err)
+	}
+	if want, _ := tut.Replace(input); got != want {
+		t.Errorf("Rewrite %q: got %q, want %q", input, got, want)
+	}
+	want := []Edit{
+		{Start: 2, End: 5, Old: "1+2", New: "2+1"},
+		{Start: 9, End: 13, Old: "30+4", New: "4+30"},
+	}
+	if !reflect.DeepEqual(edits, want) {
+		t.Errorf("Rewrite %q edits: got %+v, want %+v", input, edits, want)
+	}
+}
+
+func TestReplaceAllFunc(t *testing.T) {
+	tut := Must("<${tag}>", "", pattern.Binds{{Name: "tag", Expr: `\w+`}})
+	got, err := tut.ReplaceAllFunc("a <b> c <dd>", strings.ToUpper)
+	if err != nil {
+		t.Fatalf("ReplaceAllFunc failed: %v", err)
+	}
+	if want := "a <B> c <DD>"; got != want {
+		t.Errorf("ReplaceAllFunc: got %q, want %q", got, want)
+	}
+}
+
+func TestReplaceSep(t *testing.T) {
+	tut := Must("${k}=${v}", "${k}: ${v}", pattern.Binds{
+		{Name: "k", Expr: `\w+`}, {Name: "v", Expr: `\d+`},
+	})
+	const input = "  a=1 ,\t b=2\n\nc=3  "
+	got, err := tut.ReplaceSep(input, func(s string) string {
+		return strings.Join(strings.Fields(s), " ")
+	})
+	if err != nil {
+		t.Fatalf("ReplaceSep failed: %v", err)
+	}
+	if want := "a: 1,b: 2c: 3"; got != want {
+		t.Errorf("ReplaceSep: got %q, want %q", got, want)
+	}
+
+	plain, err := tut.ReplaceSep(input, func(s string) string { return s })
+	if err != nil {
+		t.Fatalf("ReplaceSep failed: %v", err)
+	}
+	if want, _ := tut.Replace(input); plain != want {
+		t.Errorf("ReplaceSep identity: got %q, want %q", plain, want)
+	}
+}
+
+func TestApplyFirst(t *testing.T) {
+	ts := []*T{
+		Must("${n} apples", "apples: ${n}", pattern.Binds{{Name: "n", Expr: `\d+`}}),
+		Must("${n} ${x}", "${x}: ${n}", pattern.Binds{{Name: "n", Expr: `\d+`}, {Name: "x", Expr: `\w+`}}),
+		Must("${x}", "other ${x}", pattern.Binds{{Name: "x", Expr: `\w+`}}),
+	}
+	tests := []struct {
+		input, want string
+		index       int
+	}{
+		{"5 apples", "apples: 5", 0},
+		{"3 pears", "pears: 3", 1},
+		{"kumquats", "other kumquats", 2},
+	}
+	for _, test := range tests {
+		got, i, err := ApplyFirst(ts, test.input)
+		if err != nil {
+			t.Errorf("ApplyFirst %q failed: %v", test.input, err)
+		} else if got != test.want || i != test.index {
+			t.Errorf("ApplyFirst %q: got %q, %d; want %q, %d", test.input, got, i, test.want, test.index)
+		}
+	}
+	if got, i, err := ApplyFirst(ts, "no match"); err != pattern.ErrNoMatch {
+		t.Errorf("ApplyFirst: got %q, %d, %v; want %v", got, i, err, pattern.ErrNoMatch)
+	}
+}
+
+func TestTransform(t *testing.T) {
+	tut := Must("${a} ${b} ${a}", "${b}: ${a}, ${a}", nil)
+	in := pattern.Binds{{Name: "a", Expr: "1"}, {Name: "b", Expr: "2"}, {Name: "a", Expr: "3"}}
+	got, err := tut.Transform(in)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	want := pattern.Binds{{Name: "b", Expr: "2"}, {Name: "a", Expr: "1"}, {Name: "a", Expr: "3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Transform: got %+v, want %+v", got, want)
+	}
+
+	back, err := tut.Reverse().Transform(got)
+	if err != nil {
+		t.Fatalf("Reverse Transform failed: %v", err)
+	}
+	if !reflect.DeepEqual(back, in) {
+		t.Errorf("Reverse Transform: got %+v, want %+v", back, in)
+	}
+
+	if got, err := tut.Transform(pattern.Binds{{Name: "a", Expr: "1"}}); err == nil {
+		t.Errorf("Transform: got %+v, wanted error", got)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	tut := Must("${prefix}name", "name${prefix}", pattern.Binds{
+		{Name: "prefix", Expr: `(?:re|un)?`},
+	})
+	tests := []struct {
+		input, want string
+	}{
+		{"rename", "namere"},
+		{"unname", "nameun"},
+		{"name", "name"},
+	}
+	for _, test := range tests {
+		got, err := tut.Apply(test.input)
+		if err != nil {
+			t.Errorf("Apply %q failed: %v", test.input, err)
+		} else if got != test.want {
+			t.Errorf("Apply %q: got %q, want %q", test.input, got, test.want)
+		}
+	}
+	if got, err := tut.Replace("rename or name"); err != nil {
+		t.Errorf("Replace failed: %v", err)
+	} else if want := "namere or name"; got != want {
+		t.Errorf("Replace: got %q, want %q", got, want)
+	}
+}
+
+func TestString(t *testing.T) {
+	tut := Must(`${a} "is" ${b}`, "${b}, ${a}", nil)
+	const want = `"${a} \"is\" ${b}" => "${b}, ${a}"`
+	if got := tut.String(); got != want {
+		t.Errorf("String: got %s, want %s", got, want)
+	}
+}
+
+func TestApplyMapFunc(t *testing.T) {
+	tut := Must("${verb} the ${noun}", "${noun}: ${verb}", pattern.Binds{
+		{Name: "verb", Expr: `\w+`}, {Name: "noun", Expr: `\w+`},
+	})
+	got, err := tut.ApplyMapFunc("feed the cat", func(name, value string) string {
+		if name == "noun" {
+			return strings.ToUpper(value)
+		}
+		return value
+	})
+	if err != nil {
+		t.Fatalf("ApplyMapFunc failed: %v", err)
+	}
+	if want := "CAT: feed"; got != want {
+		t.Errorf("ApplyMapFunc: got %q, want %q", got, want)
+	}
+}
+
+func TestApplyBindings(t *testing.T) {
+	tut := Must("${verb} the ${noun}", "${noun}: ${verb}", pattern.Binds{
+		{Name: "verb", Expr: `\w+`}, {Name: "noun", Expr: `\w+`},
+	})
+	got, binds, err := tut.ApplyBindings("feed the cat")
+	if err != nil {
+		t.Fatalf("ApplyBindings failed: %v", err)
+	}
+	if want := "cat: feed"; got != want {
+		t.Errorf("ApplyBindings: got %q, want %q", got, want)
+	}
+	if want := (pattern.Binds{{Name: "verb", Expr: "feed"}, {Name: "noun", Expr: "cat"}}); !reflect.DeepEqual(binds, want) {
+		t.Errorf("ApplyBindings binds: got %+v, want %+v", binds, want)
+	}
+
+	if got, binds, err := tut.ApplyBindings("nothing here"); err != pattern.ErrNoMatch || binds != nil {
+		t.Errorf("ApplyBindings mismatch: got %q, %+v, %v; want %v", got, binds, err, pattern.ErrNoMatch)
+	}
+}
+
+func TestPair(t *testing.T) {
+	fwd, rev := Must("${a}+${b}", "(+ ${a} ${b})", pattern.Binds{
+		{Name: "a", Expr: `\w+`}, {Name: "b", Expr: `\w+`},
+	}).Pair()
+	const input = "x+y"
+	const want = "(+ x y)"
+
+	got, err := fwd(input)
+	if err != nil {
+		t.Fatalf("Forward %q failed: %v", input, err)
+	} else if got != want {
+		t.Errorf("Forward %q: got %q, want %q", input, got, want)
+	}
+	if back, err := rev(got); err != nil {
+		t.Errorf("Reverse %q failed: %v", got, err)
+	} else if back != input {
+		t.Errorf("Reverse %q: got %q, want %q", got, back, input)
+	}
+}
+
 func makeBinds(ss []string) (bs pattern.Binds) {
 	for _, s := range ss {
 		bs = append(bs, pattern.Bind{Name: s})